@@ -0,0 +1,212 @@
+package ghastly
+
+import (
+	"context"
+	"fmt"
+)
+
+// A Backend is an origin server that Fastly fetches content from.
+type Backend struct {
+	Name      string
+	Comment   string
+	ServiceId string
+	Version   int64
+	Locked    bool
+	Address   string
+	Port      int64
+	// Timeouts are in milliseconds.
+	ConnectTimeout      int64
+	FirstByteTimeout    int64
+	BetweenBytesTimeout int64
+	UseSsl              bool
+	SslCheckCert        bool
+	SslCertHostname     string
+	SslSniHostname      string
+	SslCaCert           string
+	SslClientCert       string
+	SslClientKey        string
+	Weight              int64
+	AutoLoadbalance     bool
+	// The POP to shield this backend with, if any.
+	Shield string
+	// The names of the healthcheck and request condition attached to this
+	// backend, if any.
+	Healthcheck      string
+	RequestCondition string
+	version          *Version
+}
+
+// Fields for creating or updating a backend.
+type BackendInput struct {
+	Name                *string `form:"name"`
+	Comment             *string `form:"comment"`
+	Address             *string `form:"address"`
+	Port                *int64  `form:"port"`
+	ConnectTimeout      *int64  `form:"connect_timeout"`
+	FirstByteTimeout    *int64  `form:"first_byte_timeout"`
+	BetweenBytesTimeout *int64  `form:"between_bytes_timeout"`
+	UseSsl              *bool   `form:"use_ssl"`
+	SslCheckCert        *bool   `form:"ssl_check_cert"`
+	SslCertHostname     *string `form:"ssl_cert_hostname"`
+	SslSniHostname      *string `form:"ssl_sni_hostname"`
+	SslCaCert           *string `form:"ssl_ca_cert"`
+	SslClientCert       *string `form:"ssl_client_cert"`
+	SslClientKey        *string `form:"ssl_client_key"`
+	Weight              *int64  `form:"weight"`
+	AutoLoadbalance     *bool   `form:"auto_loadbalance"`
+	Shield              *string `form:"shield"`
+	Healthcheck         *string `form:"healthcheck"`
+	RequestCondition    *string `form:"request_condition"`
+}
+
+// Create a new backend for a particular version of a service. The name and
+// address are required. If a healthcheck is given, it has to exist already.
+func (v *Version) NewBackend(input *BackendInput) (*Backend, error) {
+	return v.NewBackendContext(context.Background(), input)
+}
+
+// Like NewBackend, but takes a context.Context for the request.
+func (v *Version) NewBackendContext(ctx context.Context, input *BackendInput) (*Backend, error) {
+	if input != nil {
+		if err := v.checkHealthcheckExists(ctx, input.Healthcheck); err != nil {
+			return nil, err
+		}
+	}
+	bData, err := createVersionObject[backendData](ctx, v, "backend", input)
+	if err != nil {
+		return nil, err
+	}
+	return v.populateBackend(bData)
+}
+
+// List all backends associated with a version of a service.
+func (v *Version) ListBackends() ([]*Backend, error) {
+	return v.ListBackendsContext(context.Background())
+}
+
+// Like ListBackends, but takes a context.Context for the request.
+func (v *Version) ListBackendsContext(ctx context.Context) ([]*Backend, error) {
+	bData, err := listVersionObjects[backendData](ctx, v, "backend")
+	if err != nil {
+		return nil, err
+	}
+	backends := make([]*Backend, len(bData))
+	for i := range bData {
+		b, err := v.populateBackend(&bData[i])
+		if err != nil {
+			return nil, err
+		}
+		backends[i] = b
+	}
+	return backends, nil
+}
+
+// Get a backend associated with this version. If the version has no such
+// backend, the error returned satisfies IsNotFound.
+func (v *Version) GetBackend(name string) (*Backend, error) {
+	return v.GetBackendContext(context.Background(), name)
+}
+
+// Like GetBackend, but takes a context.Context for the request.
+func (v *Version) GetBackendContext(ctx context.Context, name string) (*Backend, error) {
+	bData, err := getVersionObject[backendData](ctx, v, "backend", name)
+	if err != nil {
+		return nil, err
+	}
+	return v.populateBackend(bData)
+}
+
+// Update a backend, for the version the backend belongs to. Only the fields set
+// in input are changed, and the backend is refreshed from Fastly's response. If
+// a healthcheck is given, it has to exist already.
+func (b *Backend) Update(input *BackendInput) error {
+	return b.UpdateContext(context.Background(), input)
+}
+
+// Like Update, but takes a context.Context for the request.
+func (b *Backend) UpdateContext(ctx context.Context, input *BackendInput) error {
+	if input != nil {
+		if err := b.version.checkHealthcheckExists(ctx, input.Healthcheck); err != nil {
+			return err
+		}
+	}
+	bData, err := updateVersionObject[backendData](ctx, b.version, "backend", b.Name, input)
+	if err != nil {
+		return err
+	}
+	nb, err := b.version.populateBackend(bData)
+	if err != nil {
+		return err
+	}
+	*b = *nb
+	return nil
+}
+
+// Delete a backend, for the version the backend belongs to.
+func (b *Backend) Delete() error {
+	return b.DeleteContext(context.Background())
+}
+
+// Like Delete, but takes a context.Context for the request.
+func (b *Backend) DeleteContext(ctx context.Context) error {
+	return deleteVersionObject(ctx, b.version, "backend", b.Name)
+}
+
+// The JSON representation of a backend from the API.
+type backendData struct {
+	Name                string `json:"name"`
+	Comment             string `json:"comment"`
+	ServiceId           string `json:"service_id"`
+	Version             int64  `json:"version"`
+	Locked              bool   `json:"locked"`
+	Address             string `json:"address"`
+	Port                int64  `json:"port"`
+	ConnectTimeout      int64  `json:"connect_timeout"`
+	FirstByteTimeout    int64  `json:"first_byte_timeout"`
+	BetweenBytesTimeout int64  `json:"between_bytes_timeout"`
+	UseSsl              bool   `json:"use_ssl"`
+	SslCheckCert        bool   `json:"ssl_check_cert"`
+	SslCertHostname     string `json:"ssl_cert_hostname"`
+	SslSniHostname      string `json:"ssl_sni_hostname"`
+	SslCaCert           string `json:"ssl_ca_cert"`
+	SslClientCert       string `json:"ssl_client_cert"`
+	SslClientKey        string `json:"ssl_client_key"`
+	Weight              int64  `json:"weight"`
+	AutoLoadbalance     bool   `json:"auto_loadbalance"`
+	Shield              string `json:"shield"`
+	Healthcheck         string `json:"healthcheck"`
+	RequestCondition    string `json:"request_condition"`
+}
+
+func (v *Version) populateBackend(bd *backendData) (*Backend, error) {
+	if bd.Name == "" {
+		err := fmt.Errorf("Backend data had no name")
+		return nil, err
+	}
+	b := &Backend{
+		Name:                bd.Name,
+		Comment:             bd.Comment,
+		ServiceId:           bd.ServiceId,
+		Version:             bd.Version,
+		Locked:              bd.Locked,
+		Address:             bd.Address,
+		Port:                bd.Port,
+		ConnectTimeout:      bd.ConnectTimeout,
+		FirstByteTimeout:    bd.FirstByteTimeout,
+		BetweenBytesTimeout: bd.BetweenBytesTimeout,
+		UseSsl:              bd.UseSsl,
+		SslCheckCert:        bd.SslCheckCert,
+		SslCertHostname:     bd.SslCertHostname,
+		SslSniHostname:      bd.SslSniHostname,
+		SslCaCert:           bd.SslCaCert,
+		SslClientCert:       bd.SslClientCert,
+		SslClientKey:        bd.SslClientKey,
+		Weight:              bd.Weight,
+		AutoLoadbalance:     bd.AutoLoadbalance,
+		Shield:              bd.Shield,
+		Healthcheck:         bd.Healthcheck,
+		RequestCondition:    bd.RequestCondition,
+		version:             v,
+	}
+	return b, nil
+}
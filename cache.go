@@ -0,0 +1,211 @@
+package ghastly
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Statistics for the service and version lookup cache.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+// The fraction of lookups that were found in the cache.
+func (cs CacheStats) HitRate() float64 {
+	if cs.Hits+cs.Misses == 0 {
+		return 0
+	}
+	return float64(cs.Hits) / float64(cs.Hits+cs.Misses)
+}
+
+// The fraction of lookups that had to go to Fastly.
+func (cs CacheStats) MissRate() float64 {
+	if cs.Hits+cs.Misses == 0 {
+		return 0
+	}
+	return 1 - cs.HitRate()
+}
+
+// A cache of services and versions, kept in least recently used order so the
+// oldest entries can be evicted when it's full.
+type lookupCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+	stats      CacheStats
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// Turn on caching of GetService, SearchServices, and GetVersion results. Each
+// entry is kept for ttl, and no more than maxEntries are kept at once; if
+// maxEntries is zero or less, there's no limit. Changes made through ghastly
+// invalidate the affected entries, but changes made elsewhere won't be seen
+// until the entries expire. Cached services and versions are shared between
+// callers.
+func (g *Ghastly) EnableCache(ttl time.Duration, maxEntries int) {
+	g.cache.Store(&lookupCache{ttl: ttl, maxEntries: maxEntries, entries: make(map[string]*list.Element), lru: list.New()})
+}
+
+// Turn off caching, throwing away anything cached.
+func (g *Ghastly) DisableCache() {
+	g.cache.Store(nil)
+}
+
+// Throw away everything in the cache, if caching is on.
+func (g *Ghastly) ClearCache() {
+	c := g.lookups()
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// Return statistics for the cache. If caching is off, they're all zero.
+func (g *Ghastly) CacheStats() CacheStats {
+	c := g.lookups()
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// The cache, or nil if caching is off. Its methods are safe to call on nil.
+func (g *Ghastly) lookups() *lookupCache {
+	return g.cache.Load()
+}
+
+func serviceIdKey(id string) string {
+	return "id:" + id
+}
+
+func serviceNameKey(name string) string {
+	return "name:" + name
+}
+
+func versionKey(serviceId string, number int64) string {
+	return fmt.Sprintf("version:%s:%d", serviceId, number)
+}
+
+func (c *lookupCache) get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if ok && time.Now().After(el.Value.(*cacheEntry).expires) {
+		c.remove(el)
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry).value, true
+}
+
+func (c *lookupCache) set(key string, value interface{}) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, expires: time.Now().Add(c.ttl)})
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Remove the entries for keys, and any others that match.
+func (c *lookupCache) invalidate(match func(key string, value interface{}) bool, keys ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range keys {
+		if el, ok := c.entries[k]; ok {
+			c.remove(el)
+		}
+	}
+	if match == nil {
+		return
+	}
+	for k, el := range c.entries {
+		if match(k, el.Value.(*cacheEntry).value) {
+			c.remove(el)
+		}
+	}
+}
+
+// Must be called with the lock held.
+func (c *lookupCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+func (g *Ghastly) cacheService(s *Service) {
+	c := g.lookups()
+	c.set(serviceIdKey(s.Id), s)
+	c.set(serviceNameKey(s.Name), s)
+}
+
+// A version belongs to the Service it was looked up through, so the cache keeps
+// a copy of it and hands out copies bound to whichever Service asks.
+func (g *Ghastly) cachedVersion(s *Service, number int64) (*Version, bool) {
+	cv, ok := g.lookups().get(versionKey(s.Id, number))
+	if !ok {
+		return nil, false
+	}
+	v := *cv.(*Version)
+	v.service = s
+	return &v, true
+}
+
+func (g *Ghastly) cacheVersion(v *Version) {
+	cv := *v
+	cv.service = nil
+	g.lookups().set(versionKey(v.service.Id, v.Number), &cv)
+}
+
+// Drop a service from the cache, under its id and any name it's cached under.
+// Its versions are left alone unless withVersions is true.
+func (g *Ghastly) invalidateService(id string, withVersions bool) {
+	versionPrefix := fmt.Sprintf("version:%s:", id)
+	g.lookups().invalidate(func(key string, value interface{}) bool {
+		if s, ok := value.(*Service); ok && s.Id == id {
+			return true
+		}
+		return withVersions && strings.HasPrefix(key, versionPrefix)
+	}, serviceIdKey(id))
+}
+
+func (g *Ghastly) invalidateVersion(serviceId string, number int64) {
+	g.lookups().invalidate(nil, versionKey(serviceId, number))
+}
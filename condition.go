@@ -0,0 +1,181 @@
+package ghastly
+
+import (
+	"context"
+	"fmt"
+)
+
+// When a condition is checked, which decides what it can be attached to.
+type ConditionType string
+
+const (
+	ConditionRequest  ConditionType = "REQUEST"
+	ConditionCache    ConditionType = "CACHE"
+	ConditionResponse ConditionType = "RESPONSE"
+)
+
+// A Condition is a VCL boolean expression that decides whether a header,
+// backend, or other object applies to a request.
+type Condition struct {
+	Name      string
+	Comment   string
+	ServiceId string
+	Version   int64
+	Locked    bool
+	Type      ConditionType
+	// The VCL expression, like `req.url ~ "^/api/"`.
+	Statement string
+	// Conditions are checked in order of priority, lowest first.
+	Priority int64
+	version  *Version
+}
+
+// Fields for creating or updating a condition.
+type ConditionInput struct {
+	Name      *string        `form:"name"`
+	Comment   *string        `form:"comment"`
+	Type      *ConditionType `form:"type"`
+	Statement *string        `form:"statement"`
+	Priority  *int64         `form:"priority"`
+}
+
+// Check the condition type and statement, if they're set, before sending them
+// to Fastly.
+func (ci *ConditionInput) check() error {
+	if ci == nil {
+		return nil
+	}
+	if ci.Type != nil {
+		switch *ci.Type {
+		case ConditionRequest, ConditionCache, ConditionResponse:
+		default:
+			err := fmt.Errorf("Unknown condition type '%s'", *ci.Type)
+			return err
+		}
+	}
+	if ci.Statement != nil {
+		return CheckStatement(*ci.Statement)
+	}
+	return nil
+}
+
+// Create a new condition for a particular version of a service. The name,
+// type, and statement are required. The statement is checked with
+// CheckStatement first.
+func (v *Version) NewCondition(input *ConditionInput) (*Condition, error) {
+	return v.NewConditionContext(context.Background(), input)
+}
+
+// Like NewCondition, but takes a context.Context for the request.
+func (v *Version) NewConditionContext(ctx context.Context, input *ConditionInput) (*Condition, error) {
+	if err := input.check(); err != nil {
+		return nil, err
+	}
+	cData, err := createVersionObject[conditionData](ctx, v, "condition", input)
+	if err != nil {
+		return nil, err
+	}
+	return v.populateCondition(cData)
+}
+
+// List all conditions associated with a version of a service.
+func (v *Version) ListConditions() ([]*Condition, error) {
+	return v.ListConditionsContext(context.Background())
+}
+
+// Like ListConditions, but takes a context.Context for the request.
+func (v *Version) ListConditionsContext(ctx context.Context) ([]*Condition, error) {
+	cData, err := listVersionObjects[conditionData](ctx, v, "condition")
+	if err != nil {
+		return nil, err
+	}
+	conditions := make([]*Condition, len(cData))
+	for i := range cData {
+		c, err := v.populateCondition(&cData[i])
+		if err != nil {
+			return nil, err
+		}
+		conditions[i] = c
+	}
+	return conditions, nil
+}
+
+// Get a condition associated with this version. If the version has no such
+// condition, the error returned satisfies IsNotFound.
+func (v *Version) GetCondition(name string) (*Condition, error) {
+	return v.GetConditionContext(context.Background(), name)
+}
+
+// Like GetCondition, but takes a context.Context for the request.
+func (v *Version) GetConditionContext(ctx context.Context, name string) (*Condition, error) {
+	cData, err := getVersionObject[conditionData](ctx, v, "condition", name)
+	if err != nil {
+		return nil, err
+	}
+	return v.populateCondition(cData)
+}
+
+// Update a condition, for the version the condition belongs to. Only the fields
+// set in input are changed, and the condition is refreshed from Fastly's
+// response. A new statement is checked with CheckStatement first.
+func (c *Condition) Update(input *ConditionInput) error {
+	return c.UpdateContext(context.Background(), input)
+}
+
+// Like Update, but takes a context.Context for the request.
+func (c *Condition) UpdateContext(ctx context.Context, input *ConditionInput) error {
+	if err := input.check(); err != nil {
+		return err
+	}
+	cData, err := updateVersionObject[conditionData](ctx, c.version, "condition", c.Name, input)
+	if err != nil {
+		return err
+	}
+	nc, err := c.version.populateCondition(cData)
+	if err != nil {
+		return err
+	}
+	*c = *nc
+	return nil
+}
+
+// Delete a condition, for the version the condition belongs to.
+func (c *Condition) Delete() error {
+	return c.DeleteContext(context.Background())
+}
+
+// Like Delete, but takes a context.Context for the request.
+func (c *Condition) DeleteContext(ctx context.Context) error {
+	return deleteVersionObject(ctx, c.version, "condition", c.Name)
+}
+
+// The JSON representation of a condition from the API.
+type conditionData struct {
+	Name      string        `json:"name"`
+	Comment   string        `json:"comment"`
+	ServiceId string        `json:"service_id"`
+	Version   int64         `json:"version"`
+	Locked    bool          `json:"locked"`
+	Type      ConditionType `json:"type"`
+	Statement string        `json:"statement"`
+	Priority  flexInt       `json:"priority"`
+}
+
+func (v *Version) populateCondition(cd *conditionData) (*Condition, error) {
+	if cd.Name == "" {
+		err := fmt.Errorf("Condition data had no name")
+		return nil, err
+	}
+	c := &Condition{
+		Name:      cd.Name,
+		Comment:   cd.Comment,
+		ServiceId: cd.ServiceId,
+		Version:   cd.Version,
+		Locked:    cd.Locked,
+		Type:      cd.Type,
+		Statement: cd.Statement,
+		Priority:  int64(cd.Priority),
+		version:   v,
+	}
+	return c, nil
+}
@@ -0,0 +1,214 @@
+package ghastly
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Options for Service.Deploy. The zero value is fine to use.
+type DeployOptions struct {
+	// The version to clone. If zero, the active version is cloned, or
+	// the latest version if none is active.
+	BaseVersion int64
+	// Comment to set on the new version.
+	Comment string
+	// Checks to run after the new version is activated. If any of them
+	// fail, the previous version is activated again.
+	HealthChecks []func(context.Context, *Version) error
+	// How often to check whether the new version has become active. If
+	// zero, ActivateAndWait's default is used.
+	PollInterval time.Duration
+}
+
+// What happened during a deploy.
+type DeployReport struct {
+	// The version that was active before the deploy, or zero if none
+	// was.
+	PreviousVersion int64
+	// The version the deploy created.
+	NewVersion int64
+	// The result of validating the new version, if it got that far.
+	Validation *Validation
+	Activated  bool
+	RolledBack bool
+	Started    time.Time
+	Finished   time.Time
+	// How long each step took.
+	CloneTime       time.Duration
+	MutateTime      time.Duration
+	ValidateTime    time.Duration
+	ActivateTime    time.Duration
+	HealthCheckTime time.Duration
+}
+
+// Deploy a change to the service: clone the active version, call mutate to
+// make changes to the clone, validate it, and activate it. If mutate or
+// validation fails, the clone is left inactive and marked as abandoned. If any
+// of the health checks fail after activating it, the previous version is
+// activated again. The report is returned even if the deploy fails.
+func (s *Service) Deploy(mutate func(*Version) error, opts *DeployOptions) (*DeployReport, error) {
+	return s.DeployContext(context.Background(), mutate, opts)
+}
+
+// Like Deploy, but takes a context.Context for the requests.
+func (s *Service) DeployContext(ctx context.Context, mutate func(*Version) error, opts *DeployOptions) (report *DeployReport, err error) {
+	if opts == nil {
+		opts = new(DeployOptions)
+	}
+	report = &DeployReport{Started: time.Now()}
+	defer func() {
+		report.Finished = time.Now()
+	}()
+	step := func(d *time.Duration, f func() error) error {
+		start := time.Now()
+		err := f()
+		*d = time.Since(start)
+		return err
+	}
+
+	var base, clone *Version
+	err = step(&report.CloneTime, func() error {
+		var err error
+		base, err = s.deployBase(ctx, opts.BaseVersion)
+		if err != nil {
+			return err
+		}
+		report.PreviousVersion = s.ActiveVersion
+		clone, err = base.CloneContext(ctx)
+		if err != nil {
+			return err
+		}
+		report.NewVersion = clone.Number
+		if opts.Comment != "" {
+			return clone.UpdateContext(ctx, opts.Comment)
+		}
+		return nil
+	})
+	if err != nil {
+		// the clone is there even if setting its comment failed
+		if clone != nil {
+			return report, clone.abandon(ctx, err)
+		}
+		return report, err
+	}
+
+	err = step(&report.MutateTime, func() error {
+		return mutate(clone)
+	})
+	if err != nil {
+		return report, clone.abandon(ctx, err)
+	}
+
+	err = step(&report.ValidateTime, func() error {
+		val, err := clone.ValidateContext(ctx)
+		if err != nil {
+			return err
+		}
+		report.Validation = val
+		return val.Err()
+	})
+	if err != nil {
+		return report, clone.abandon(ctx, err)
+	}
+
+	// once the activation goes through the new version is live, even if
+	// waiting to see it take effect fails
+	err = step(&report.ActivateTime, func() error {
+		if err := clone.ActivateContext(ctx); err != nil {
+			return err
+		}
+		report.Activated = true
+		return clone.waitActive(ctx, opts.PollInterval)
+	})
+	if err != nil {
+		if !report.Activated {
+			return report, err
+		}
+		return report, s.rollbackDeploy(ctx, report, clone, "Waiting for activation", err)
+	}
+
+	err = step(&report.HealthCheckTime, func() error {
+		for _, check := range opts.HealthChecks {
+			if err := check(ctx, clone); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, s.rollbackDeploy(ctx, report, clone, "Health check", err)
+	}
+	return report, nil
+}
+
+// How long to spend putting the previous version back after a failed deploy.
+const restoreTimeout = time.Minute
+
+// Put the previous version back after the new one failed once it was active,
+// and return an error saying what happened. The rollback still goes ahead if
+// ctx is done, since that may be why the deploy failed.
+func (s *Service) rollbackDeploy(ctx context.Context, report *DeployReport, failed *Version, what string, cause error) error {
+	rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), restoreTimeout)
+	defer cancel()
+	if rerr := s.restore(rctx, report.PreviousVersion, failed); rerr != nil {
+		err := fmt.Errorf("%s for version %d failed: %s, and rolling back to version %d also failed: %s", what, failed.Number, cause, report.PreviousVersion, rerr)
+		return err
+	}
+	report.RolledBack = true
+	err := fmt.Errorf("%s for version %d failed, rolled back to version %d: %w", what, failed.Number, report.PreviousVersion, cause)
+	return err
+}
+
+// Find the version a deploy should start from.
+func (s *Service) deployBase(ctx context.Context, number int64) (*Version, error) {
+	// make sure we know what's active right now
+	current, err := s.ghastly.fetchService(ctx, s.Id)
+	if err != nil {
+		return nil, err
+	}
+	s.setActiveVersion(current.ActiveVersion)
+	if number != 0 {
+		return s.GetVersionContext(ctx, number)
+	}
+	if s.ActiveVersion != 0 {
+		return s.GetVersionContext(ctx, s.ActiveVersion)
+	}
+	versions, err := s.ListVersionsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		err = fmt.Errorf("Service %s has no versions to deploy from", s.Name)
+		return nil, err
+	}
+	return versions[len(versions)-1], nil
+}
+
+// Put the previous version back after a failed deploy, or deactivate the new
+// version if nothing was active before.
+func (s *Service) restore(ctx context.Context, previous int64, failed *Version) error {
+	if previous == 0 {
+		return failed.DeactivateContext(ctx)
+	}
+	prev, err := s.GetVersionContext(ctx, previous)
+	if err != nil {
+		return err
+	}
+	return prev.ActivateContext(ctx)
+}
+
+// Mark a version from a failed deploy as abandoned, since versions can't be
+// deleted. The original error is returned, noting if marking the version
+// failed too.
+func (v *Version) abandon(ctx context.Context, cause error) error {
+	comment := fmt.Sprintf("Abandoned deploy: %s", cause)
+	if v.Comment != "" {
+		comment = fmt.Sprintf("%s (%s)", comment, v.Comment)
+	}
+	if err := v.UpdateContext(ctx, comment); err != nil {
+		err = fmt.Errorf("%w (marking version %d as abandoned also failed: %s)", cause, v.Number, err)
+		return err
+	}
+	return cause
+}
@@ -0,0 +1,146 @@
+package ghastly
+
+import (
+	"context"
+	"fmt"
+)
+
+// Detailed information about a service: the service itself, all of its
+// versions, and the active and current versions with everything attached to
+// them.
+type ServiceDetails struct {
+	*Service
+	// All of the service's versions, in order.
+	Versions []*Version
+	// The version that's live, or nil if no version is active.
+	Active *VersionDetails
+	// The latest version of the service.
+	Current *VersionDetails
+}
+
+// A version along with the objects attached to it.
+type VersionDetails struct {
+	*Version
+	Domains      []*Domain
+	Backends     []*Backend
+	Healthchecks []*Healthcheck
+	Directors    []*Director
+	Conditions   []*Condition
+	Headers      []*Header
+}
+
+// The JSON representation of a service's details. Unlike everywhere else,
+// "version" here is the current version rather than the active version number.
+type serviceDetailsData struct {
+	serviceData
+	Version       *versionDetailsData `json:"version"`
+	ActiveVersion *versionDetailsData `json:"active_version"`
+}
+
+type versionDetailsData struct {
+	versionData
+	Domains      []domainData      `json:"domains"`
+	Backends     []backendData     `json:"backends"`
+	Healthchecks []healthcheckData `json:"healthchecks"`
+	Directors    []directorData    `json:"directors"`
+	Conditions   []conditionData   `json:"conditions"`
+	Headers      []headerData      `json:"headers"`
+}
+
+// Get detailed information about a service. The service's ActiveVersion is
+// updated as well.
+func (s *Service) Details() (*ServiceDetails, error) {
+	return s.DetailsContext(context.Background())
+}
+
+// Like Details, but takes a context.Context for the request.
+func (s *Service) DetailsContext(ctx context.Context) (*ServiceDetails, error) {
+	detailURL := makeServiceURL(fmt.Sprintf("%s/details", s.Id))
+	resp, err := s.ghastly.GetContext(ctx, detailURL)
+	if err != nil {
+		return nil, err
+	}
+	sData, err := ParseJsonTyped[serviceDetailsData](resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	details, err := s.ghastly.populateServiceDetails(&sData)
+	if err != nil {
+		return nil, err
+	}
+	s.setActiveVersion(details.Service.ActiveVersion)
+	return details, nil
+}
+
+func (g *Ghastly) populateServiceDetails(sd *serviceDetailsData) (*ServiceDetails, error) {
+	svc, err := g.populateService(&sd.serviceData)
+	if err != nil {
+		return nil, err
+	}
+	details := &ServiceDetails{Service: svc}
+	if details.Current, err = svc.populateVersionDetails(sd.Version); err != nil {
+		return nil, err
+	}
+	if details.Active, err = svc.populateVersionDetails(sd.ActiveVersion); err != nil {
+		return nil, err
+	}
+	if details.Active != nil {
+		svc.setActiveVersion(details.Active.Number)
+	}
+	details.Versions = svc.cachedVersions()
+	return details, nil
+}
+
+func (s *Service) populateVersionDetails(vd *versionDetailsData) (*VersionDetails, error) {
+	if vd == nil {
+		return nil, nil
+	}
+	v, err := s.populateVersion(&vd.versionData)
+	if err != nil {
+		return nil, err
+	}
+	details := &VersionDetails{Version: v}
+	for i := range vd.Domains {
+		d, err := v.populateDomain(&vd.Domains[i])
+		if err != nil {
+			return nil, err
+		}
+		details.Domains = append(details.Domains, d)
+	}
+	for i := range vd.Backends {
+		b, err := v.populateBackend(&vd.Backends[i])
+		if err != nil {
+			return nil, err
+		}
+		details.Backends = append(details.Backends, b)
+	}
+	for i := range vd.Healthchecks {
+		h, err := v.populateHealthcheck(&vd.Healthchecks[i])
+		if err != nil {
+			return nil, err
+		}
+		details.Healthchecks = append(details.Healthchecks, h)
+	}
+	for i := range vd.Directors {
+		d, err := v.populateDirector(&vd.Directors[i])
+		if err != nil {
+			return nil, err
+		}
+		details.Directors = append(details.Directors, d)
+	}
+	for i := range vd.Conditions {
+		c, err := v.populateCondition(&vd.Conditions[i])
+		if err != nil {
+			return nil, err
+		}
+		details.Conditions = append(details.Conditions, c)
+	}
+	for i := range vd.Headers {
+		h, err := v.populateHeader(&vd.Headers[i])
+		if err != nil {
+			return nil, err
+		}
+		details.Headers = append(details.Headers, h)
+	}
+	return details, nil
+}
@@ -0,0 +1,326 @@
+package ghastly
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Formats Fastly can produce diffs between versions in.
+type DiffFormat string
+
+const (
+	DiffText       DiffFormat = "text"
+	DiffHTML       DiffFormat = "html"
+	DiffHTMLSimple DiffFormat = "html_simple"
+)
+
+// A diff between two versions of a service's configuration, as produced by
+// Fastly.
+type Diff struct {
+	From   int64      `json:"from"`
+	To     int64      `json:"to"`
+	Format DiffFormat `json:"format"`
+	Diff   string     `json:"diff"`
+}
+
+// Get Fastly's diff between two versions of the service, in the given format.
+// If format is empty, a text diff is returned.
+func (s *Service) Diff(from, to int64, format DiffFormat) (*Diff, error) {
+	return s.DiffContext(context.Background(), from, to, format)
+}
+
+// Like Diff, but takes a context.Context for the request.
+func (s *Service) DiffContext(ctx context.Context, from, to int64, format DiffFormat) (*Diff, error) {
+	if format == "" {
+		format = DiffText
+	}
+	u := fmt.Sprintf("diff/from/%d/to/%d", from, to)
+	url := s.TaskURL(u)
+	params := map[string]string{"format": string(format)}
+	resp, err := s.ghastly.GetParamsContext(ctx, url, params)
+	if err != nil {
+		return nil, err
+	}
+	d, err := ParseJsonTyped[Diff](resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// How an object differs between two versions.
+type ChangeType string
+
+const (
+	Added    ChangeType = "added"
+	Removed  ChangeType = "removed"
+	Modified ChangeType = "modified"
+)
+
+// A change to one object, like a domain, between two versions.
+type Change struct {
+	Type ChangeType
+	// The kind of object, like "domain".
+	Kind string
+	Name string
+	// The fields that changed, for modified objects.
+	Fields []*FieldChange
+}
+
+// A change to one field of an object.
+type FieldChange struct {
+	Field string
+	From  string
+	To    string
+}
+
+// The differences between two versions of a service, worked out by comparing
+// the objects attached to each of them.
+type DiffReport struct {
+	From    int64
+	To      int64
+	Changes []*Change
+}
+
+// Returns true if nothing changed between the two versions.
+func (r *DiffReport) Empty() bool {
+	return len(r.Changes) == 0
+}
+
+// Format the report as a Markdown list, suitable for a pull request comment.
+func (r *DiffReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### Changes from version %d to version %d\n\n", r.From, r.To)
+	if r.Empty() {
+		b.WriteString("No changes.\n")
+		return b.String()
+	}
+	for _, c := range r.Changes {
+		ct := string(c.Type)
+		fmt.Fprintf(&b, "- %s%s %s `%s`", strings.ToUpper(ct[:1]), ct[1:], c.Kind, c.Name)
+		if len(c.Fields) == 0 {
+			b.WriteString("\n")
+			continue
+		}
+		b.WriteString(":\n")
+		for _, f := range c.Fields {
+			fmt.Fprintf(&b, "  - `%s`: `%s` → `%s`\n", f.Field, f.From, f.To)
+		}
+	}
+	return b.String()
+}
+
+// The objects of one kind attached to a version, by name, with their fields as
+// strings.
+type diffObjects map[string]map[string]string
+
+// A kind of object to compare, and how to get them from a version.
+type diffSource struct {
+	kind  string
+	fetch func(context.Context, *Version) (diffObjects, error)
+}
+
+var diffSources = []diffSource{
+	{"domain", domainDiffObjects},
+	{"backend", backendDiffObjects},
+	{"healthcheck", healthcheckDiffObjects},
+	{"director", directorDiffObjects},
+	{"condition", conditionDiffObjects},
+	{"header", headerDiffObjects},
+}
+
+func domainDiffObjects(ctx context.Context, v *Version) (diffObjects, error) {
+	domains, err := v.ListDomainsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	objs := make(diffObjects, len(domains))
+	for _, d := range domains {
+		objs[d.Name] = map[string]string{"comment": d.Comment}
+	}
+	return objs, nil
+}
+
+func backendDiffObjects(ctx context.Context, v *Version) (diffObjects, error) {
+	backends, err := listVersionObjects[backendData](ctx, v, "backend")
+	if err != nil {
+		return nil, err
+	}
+	objs := make(diffObjects, len(backends))
+	for i := range backends {
+		objs[backends[i].Name] = dataFields(&backends[i])
+	}
+	return objs, nil
+}
+
+func healthcheckDiffObjects(ctx context.Context, v *Version) (diffObjects, error) {
+	healthchecks, err := listVersionObjects[healthcheckData](ctx, v, "healthcheck")
+	if err != nil {
+		return nil, err
+	}
+	objs := make(diffObjects, len(healthchecks))
+	for i := range healthchecks {
+		objs[healthchecks[i].Name] = dataFields(&healthchecks[i])
+	}
+	return objs, nil
+}
+
+func directorDiffObjects(ctx context.Context, v *Version) (diffObjects, error) {
+	directors, err := listVersionObjects[directorData](ctx, v, "director")
+	if err != nil {
+		return nil, err
+	}
+	objs := make(diffObjects, len(directors))
+	for i := range directors {
+		fields := dataFields(&directors[i])
+		// the order backends were added in doesn't matter
+		backends := append([]string{}, directors[i].Backends...)
+		sort.Strings(backends)
+		fields["backends"] = strings.Join(backends, ", ")
+		objs[directors[i].Name] = fields
+	}
+	return objs, nil
+}
+
+func conditionDiffObjects(ctx context.Context, v *Version) (diffObjects, error) {
+	conditions, err := listVersionObjects[conditionData](ctx, v, "condition")
+	if err != nil {
+		return nil, err
+	}
+	objs := make(diffObjects, len(conditions))
+	for i := range conditions {
+		objs[conditions[i].Name] = dataFields(&conditions[i])
+	}
+	return objs, nil
+}
+
+func headerDiffObjects(ctx context.Context, v *Version) (diffObjects, error) {
+	headers, err := listVersionObjects[headerData](ctx, v, "header")
+	if err != nil {
+		return nil, err
+	}
+	objs := make(diffObjects, len(headers))
+	for i := range headers {
+		objs[headers[i].Name] = dataFields(&headers[i])
+	}
+	return objs, nil
+}
+
+// The fields of an object's JSON representation as strings, by their JSON
+// names, leaving out the ones that identify it rather than configure it.
+func dataFields(data interface{}) map[string]string {
+	fields := make(map[string]string)
+	rv := reflect.ValueOf(data).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		name, _, _ := strings.Cut(rt.Field(i).Tag.Get("json"), ",")
+		switch name {
+		case "", "-", "name", "service_id", "version", "locked":
+			continue
+		}
+		fields[name] = fmt.Sprint(rv.Field(i).Interface())
+	}
+	return fields
+}
+
+// Work out the differences between two versions of the service by fetching
+// the objects attached to each and comparing them, rather than relying on
+// Fastly's diff.
+func (s *Service) StructuralDiff(from, to int64) (*DiffReport, error) {
+	return s.StructuralDiffContext(context.Background(), from, to)
+}
+
+// Like StructuralDiff, but takes a context.Context for the requests.
+func (s *Service) StructuralDiffContext(ctx context.Context, from, to int64) (*DiffReport, error) {
+	fromV, err := s.GetVersionContext(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	toV, err := s.GetVersionContext(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	report := &DiffReport{From: from, To: to}
+	for _, src := range diffSources {
+		fromObjs, err := src.fetch(ctx, fromV)
+		if err != nil {
+			return nil, err
+		}
+		toObjs, err := src.fetch(ctx, toV)
+		if err != nil {
+			return nil, err
+		}
+		report.Changes = append(report.Changes, diffKind(src.kind, fromObjs, toObjs)...)
+	}
+	return report, nil
+}
+
+func diffKind(kind string, from, to diffObjects) []*Change {
+	var changes []*Change
+	for _, name := range sortedKeys(from) {
+		if _, ok := to[name]; !ok {
+			changes = append(changes, &Change{Type: Removed, Kind: kind, Name: name})
+		}
+	}
+	for _, name := range sortedKeys(to) {
+		fromFields, ok := from[name]
+		if !ok {
+			changes = append(changes, &Change{Type: Added, Kind: kind, Name: name})
+			continue
+		}
+		var fields []*FieldChange
+		toFields := to[name]
+		for _, f := range sortedKeys(mergeKeys(fromFields, toFields)) {
+			if fromFields[f] != toFields[f] {
+				fc := &FieldChange{Field: f, From: fromFields[f], To: toFields[f]}
+				if secretFields[f] {
+					fc.From, fc.To = redactSecret(fc.From), redactSecret(fc.To)
+					if fc.From == fc.To {
+						fc.To = "(changed)"
+					}
+				}
+				fields = append(fields, fc)
+			}
+		}
+		if len(fields) > 0 {
+			changes = append(changes, &Change{Type: Modified, Kind: kind, Name: name, Fields: fields})
+		}
+	}
+	return changes
+}
+
+// Fields whose values, like private keys, shouldn't show up in diffs. Changes
+// to them are still reported.
+var secretFields = map[string]bool{
+	"ssl_client_key": true,
+}
+
+func redactSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "(set)"
+}
+
+func mergeKeys(a, b map[string]string) map[string]string {
+	m := make(map[string]string, len(a)+len(b))
+	for k := range a {
+		m[k] = ""
+	}
+	for k := range b {
+		m[k] = ""
+	}
+	return m
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,256 @@
+package ghastly
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// How a director picks which of its backends to send a request to.
+type DirectorType int64
+
+const (
+	DirectorRandom     DirectorType = 1
+	DirectorRoundRobin DirectorType = 2
+	DirectorHash       DirectorType = 3
+	DirectorClient     DirectorType = 4
+)
+
+func (t DirectorType) String() string {
+	switch t {
+	case DirectorRandom:
+		return "random"
+	case DirectorRoundRobin:
+		return "round-robin"
+	case DirectorHash:
+		return "hash"
+	case DirectorClient:
+		return "client"
+	}
+	return fmt.Sprintf("DirectorType(%d)", int64(t))
+}
+
+// A Director balances requests across a group of backends.
+type Director struct {
+	Name      string
+	Comment   string
+	ServiceId string
+	Version   int64
+	Locked    bool
+	Type      DirectorType
+	// The percentage of backends that have to be healthy for the director
+	// to be considered healthy.
+	Quorum int64
+	// How many times to try other backends when one fails.
+	Retries int64
+	Shield  string
+	// The names of the backends in this director. Backends resolves them.
+	BackendNames []string
+	version      *Version
+}
+
+// Fields for creating or updating a director.
+type DirectorInput struct {
+	Name    *string       `form:"name"`
+	Comment *string       `form:"comment"`
+	Type    *DirectorType `form:"type"`
+	Quorum  *int64        `form:"quorum"`
+	Retries *int64        `form:"retries"`
+	Shield  *string       `form:"shield"`
+}
+
+// Create a new director for a particular version of a service. The name is
+// required.
+func (v *Version) NewDirector(input *DirectorInput) (*Director, error) {
+	return v.NewDirectorContext(context.Background(), input)
+}
+
+// Like NewDirector, but takes a context.Context for the request.
+func (v *Version) NewDirectorContext(ctx context.Context, input *DirectorInput) (*Director, error) {
+	dData, err := createVersionObject[directorData](ctx, v, "director", input)
+	if err != nil {
+		return nil, err
+	}
+	return v.populateDirector(dData)
+}
+
+// List all directors associated with a version of a service.
+func (v *Version) ListDirectors() ([]*Director, error) {
+	return v.ListDirectorsContext(context.Background())
+}
+
+// Like ListDirectors, but takes a context.Context for the request.
+func (v *Version) ListDirectorsContext(ctx context.Context) ([]*Director, error) {
+	dData, err := listVersionObjects[directorData](ctx, v, "director")
+	if err != nil {
+		return nil, err
+	}
+	directors := make([]*Director, len(dData))
+	for i := range dData {
+		d, err := v.populateDirector(&dData[i])
+		if err != nil {
+			return nil, err
+		}
+		directors[i] = d
+	}
+	return directors, nil
+}
+
+// Get a director associated with this version. If the version has no such
+// director, the error returned satisfies IsNotFound.
+func (v *Version) GetDirector(name string) (*Director, error) {
+	return v.GetDirectorContext(context.Background(), name)
+}
+
+// Like GetDirector, but takes a context.Context for the request.
+func (v *Version) GetDirectorContext(ctx context.Context, name string) (*Director, error) {
+	dData, err := getVersionObject[directorData](ctx, v, "director", name)
+	if err != nil {
+		return nil, err
+	}
+	return v.populateDirector(dData)
+}
+
+// Update a director, for the version the director belongs to. Only the fields
+// set in input are changed, and the director is refreshed from Fastly's
+// response.
+func (d *Director) Update(input *DirectorInput) error {
+	return d.UpdateContext(context.Background(), input)
+}
+
+// Like Update, but takes a context.Context for the request.
+func (d *Director) UpdateContext(ctx context.Context, input *DirectorInput) error {
+	dData, err := updateVersionObject[directorData](ctx, d.version, "director", d.Name, input)
+	if err != nil {
+		return err
+	}
+	nd, err := d.version.populateDirector(dData)
+	if err != nil {
+		return err
+	}
+	*d = *nd
+	return nil
+}
+
+// Delete a director, for the version the director belongs to.
+func (d *Director) Delete() error {
+	return d.DeleteContext(context.Background())
+}
+
+// Like Delete, but takes a context.Context for the request.
+func (d *Director) DeleteContext(ctx context.Context) error {
+	return deleteVersionObject(ctx, d.version, "director", d.Name)
+}
+
+// Add the named backend to the director.
+func (d *Director) AddBackend(name string) error {
+	return d.AddBackendContext(context.Background(), name)
+}
+
+// Like AddBackend, but takes a context.Context for the request.
+func (d *Director) AddBackendContext(ctx context.Context, name string) error {
+	url := d.version.baseURL(d.backendPath(name))
+	resp, err := d.version.service.ghastly.PostFormContext(ctx, url, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	for _, b := range d.BackendNames {
+		if b == name {
+			return nil
+		}
+	}
+	d.BackendNames = append(d.BackendNames, name)
+	return nil
+}
+
+// Remove the named backend from the director. The backend itself isn't
+// deleted.
+func (d *Director) RemoveBackend(name string) error {
+	return d.RemoveBackendContext(context.Background(), name)
+}
+
+// Like RemoveBackend, but takes a context.Context for the request.
+func (d *Director) RemoveBackendContext(ctx context.Context, name string) error {
+	url := d.version.baseURL(d.backendPath(name))
+	resp, err := d.version.service.ghastly.DeleteContext(ctx, url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	names := make([]string, 0, len(d.BackendNames))
+	for _, b := range d.BackendNames {
+		if b != name {
+			names = append(names, b)
+		}
+	}
+	d.BackendNames = names
+	return nil
+}
+
+// The path to the director's membership of a backend.
+func (d *Director) backendPath(name string) string {
+	return fmt.Sprintf("%s/backend/%s", versionObjectPath("director", d.Name), url.PathEscape(name))
+}
+
+// Get the backends in this director, in the order they were added.
+func (d *Director) Backends() ([]*Backend, error) {
+	return d.BackendsContext(context.Background())
+}
+
+// Like Backends, but takes a context.Context for the request.
+func (d *Director) BackendsContext(ctx context.Context) ([]*Backend, error) {
+	all, err := d.version.ListBackendsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*Backend, len(all))
+	for _, b := range all {
+		byName[b.Name] = b
+	}
+	backends := make([]*Backend, len(d.BackendNames))
+	for i, name := range d.BackendNames {
+		b, ok := byName[name]
+		if !ok {
+			err := fmt.Errorf("Director '%s' has backend '%s', but version %d has no such backend", d.Name, name, d.Version)
+			return nil, err
+		}
+		backends[i] = b
+	}
+	return backends, nil
+}
+
+// The JSON representation of a director from the API.
+type directorData struct {
+	Name      string       `json:"name"`
+	Comment   string       `json:"comment"`
+	ServiceId string       `json:"service_id"`
+	Version   int64        `json:"version"`
+	Locked    bool         `json:"locked"`
+	Type      DirectorType `json:"type"`
+	Quorum    int64        `json:"quorum"`
+	Retries   int64        `json:"retries"`
+	Shield    string       `json:"shield"`
+	Backends  []string     `json:"backends"`
+}
+
+func (v *Version) populateDirector(dd *directorData) (*Director, error) {
+	if dd.Name == "" {
+		err := fmt.Errorf("Director data had no name")
+		return nil, err
+	}
+	d := &Director{
+		Name:         dd.Name,
+		Comment:      dd.Comment,
+		ServiceId:    dd.ServiceId,
+		Version:      dd.Version,
+		Locked:       dd.Locked,
+		Type:         dd.Type,
+		Quorum:       dd.Quorum,
+		Retries:      dd.Retries,
+		Shield:       dd.Shield,
+		BackendNames: dd.Backends,
+		version:      v,
+	}
+	return d, nil
+}
@@ -1,6 +1,8 @@
 package ghastly
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 )
 
@@ -13,42 +15,62 @@ type Domain struct {
 	version   *Version
 }
 
+// Fields for creating or updating a domain.
+type DomainInput struct {
+	Name    *string `form:"name"`
+	Comment *string `form:"comment"`
+}
+
 type DomainCheck struct {
 	*Domain
 	Cname    string
 	IsProper bool
 }
 
-// Create a new domain for a particular version of a service. Possible parameters
-// are "name" and "comment".
-func (v *Version) NewDomain(params map[string]string) (*Domain, error) {
+// Create a new domain for a particular version of a service. The name is
+// required.
+func (v *Version) NewDomain(input *DomainInput) (*Domain, error) {
+	return v.NewDomainContext(context.Background(), input)
+}
+
+// Like NewDomain, but takes a context.Context for the request.
+func (v *Version) NewDomainContext(ctx context.Context, input *DomainInput) (*Domain, error) {
+	params, err := encodeParams(input)
+	if err != nil {
+		return nil, err
+	}
 	url := v.baseURL("domain")
-	resp, err := v.service.ghastly.PostFormParams(url, params)
+	resp, err := v.service.ghastly.PostFormContext(ctx, url, params)
 	if err != nil {
 		return nil, err
 	}
-	dData, err := ParseJson(resp.Body)
+	dData, err := ParseJsonTyped[domainData](resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	return v.populateDomain(dData)
+	return v.populateDomain(&dData)
 }
 
 // Check all domains associated with a version of a service.
 func (v *Version) CheckAllDomains() ([]*DomainCheck, error) {
+	return v.CheckAllDomainsContext(context.Background())
+}
+
+// Like CheckAllDomains, but takes a context.Context for the request.
+func (v *Version) CheckAllDomainsContext(ctx context.Context) ([]*DomainCheck, error) {
 	url := v.baseURL("domain/check_all")
-	resp, err := v.service.ghastly.Get(url)
+	resp, err := v.service.ghastly.GetContext(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	dData, err := ParseJsonArray(resp.Body)
+	dData, err := ParseJsonArrayTyped[[]json.RawMessage](resp.Body)
 	if err != nil {
 		return nil, err
 	}
 	checkedDomains := make([]*DomainCheck, len(dData))
 	for i, dc := range dData {
-		d, err := v.populateDomainCheck(dc.([]interface{}))
+		d, err := v.populateDomainCheck(dc)
 		if err != nil {
 			return nil, err
 		}
@@ -59,13 +81,18 @@ func (v *Version) CheckAllDomains() ([]*DomainCheck, error) {
 
 // Check one domain associated with a service.
 func (v *Version) CheckDomain(name string) (*DomainCheck, error) {
-	task := fmt.Sprintf("domain/%s/check", name)
+	return v.CheckDomainContext(context.Background(), name)
+}
+
+// Like CheckDomain, but takes a context.Context for the request.
+func (v *Version) CheckDomainContext(ctx context.Context, name string) (*DomainCheck, error) {
+	task := versionObjectPath("domain", name) + "/check"
 	url := v.baseURL(task)
-	resp, err := v.service.ghastly.Get(url)
+	resp, err := v.service.ghastly.GetContext(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	dData, err := ParseJsonArray(resp.Body)
+	dData, err := ParseJsonArrayTyped[json.RawMessage](resp.Body)
 	if err != nil {
 		return nil, err
 	}
@@ -74,18 +101,23 @@ func (v *Version) CheckDomain(name string) (*DomainCheck, error) {
 
 // List all domains associated with a version of a service.
 func (v *Version) ListDomains() ([]*Domain, error) {
+	return v.ListDomainsContext(context.Background())
+}
+
+// Like ListDomains, but takes a context.Context for the request.
+func (v *Version) ListDomainsContext(ctx context.Context) ([]*Domain, error) {
 	url := v.baseURL("domain")
-	resp, err := v.service.ghastly.Get(url)
+	resp, err := v.service.ghastly.GetContext(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	dData, err := ParseJsonArray(resp.Body)
+	dData, err := ParseJsonArrayTyped[domainData](resp.Body)
 	if err != nil {
 		return nil, err
 	}
 	domains := make([]*Domain, len(dData))
-	for i, dc := range dData {
-		d, err := v.populateDomain(dc.(map[string]interface{}))
+	for i := range dData {
+		d, err := v.populateDomain(&dData[i])
 		if err != nil {
 			return nil, err
 		}
@@ -94,61 +126,111 @@ func (v *Version) ListDomains() ([]*Domain, error) {
 	return domains, nil
 }
 
-// Get a domain associated with this version
+// Get a domain associated with this version. If the version has no such
+// domain, the error returned satisfies IsNotFound.
 func (v *Version) GetDomain(name string) (*Domain, error) {
-	task := fmt.Sprintf("domain/%s", name)
+	return v.GetDomainContext(context.Background(), name)
+}
+
+// Like GetDomain, but takes a context.Context for the request.
+func (v *Version) GetDomainContext(ctx context.Context, name string) (*Domain, error) {
+	task := versionObjectPath("domain", name)
 	url := v.baseURL(task)
-	resp, err := v.service.ghastly.Get(url)
+	resp, err := v.service.ghastly.GetContext(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	dData, err := ParseJson(resp.Body)
+	dData, err := ParseJsonTyped[domainData](resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	return v.populateDomain(dData)
+	return v.populateDomain(&dData)
 }
 
 // Delete a domain, for the version the domain belongs to.
 func (d *Domain) Delete() error {
-	task := fmt.Sprintf("domain/%s", d.Name)
+	return d.DeleteContext(context.Background())
+}
+
+// Like Delete, but takes a context.Context for the request.
+func (d *Domain) DeleteContext(ctx context.Context) error {
+	task := versionObjectPath("domain", d.Name)
 	url := d.version.baseURL(task)
-	_, err := d.version.service.ghastly.Delete(url)
+	resp, err := d.version.service.ghastly.DeleteContext(ctx, url)
 	if err != nil {
 		return err
 	}
+	resp.Body.Close()
 	return nil
 }
 
-// Update a domain, for the version the domain belongs to. Possible parameters
-// for the domain are "name" and "comment".
-func (d *Domain) Update(params map[string]string) error {
-	task := fmt.Sprintf("domain/%s", d.Name)
+// Update a domain, for the version the domain belongs to. Only the fields set in
+// input are changed, and the domain is refreshed from Fastly's response.
+func (d *Domain) Update(input *DomainInput) error {
+	return d.UpdateContext(context.Background(), input)
+}
+
+// Like Update, but takes a context.Context for the request.
+func (d *Domain) UpdateContext(ctx context.Context, input *DomainInput) error {
+	params, err := encodeParams(input)
+	if err != nil {
+		return err
+	}
+	task := versionObjectPath("domain", d.Name)
 	url := d.version.baseURL(task)
-	_, err := d.version.service.ghastly.PutParams(url, params)
+	resp, err := d.version.service.ghastly.PutContext(ctx, url, params, "application/x-www-form-urlencoded")
 	if err != nil {
 		return err
 	}
-	d.Name = params["name"]
-	if c, ok := params["comment"]; ok {
-		d.Comment = c
+	dData, err := ParseJsonTyped[domainData](resp.Body)
+	if err != nil {
+		return err
+	}
+	nd, err := d.version.populateDomain(&dData)
+	if err != nil {
+		return err
 	}
+	*d = *nd
 	return nil
 }
 
-func (v *Version) populateDomain(domainData map[string]interface{}) (*Domain, error) {
-	locked, _ := domainData["locked"].(bool)
-	comment, _ := domainData["comment"].(string)
-	return &Domain{Name: domainData["name"].(string), Comment: comment, Locked: locked, ServiceId: domainData["service_id"].(string), Version: int64(domainData["version"].(float64)), version: v}, nil
+// The JSON representation of a domain from the API.
+type domainData struct {
+	Name      string `json:"name"`
+	Comment   string `json:"comment"`
+	ServiceId string `json:"service_id"`
+	Version   int64  `json:"version"`
+	Locked    bool   `json:"locked"`
+}
+
+func (v *Version) populateDomain(dd *domainData) (*Domain, error) {
+	if dd.Name == "" {
+		err := fmt.Errorf("Domain data had no name")
+		return nil, err
+	}
+	return &Domain{Name: dd.Name, Comment: dd.Comment, Locked: dd.Locked, ServiceId: dd.ServiceId, Version: dd.Version, version: v}, nil
 }
 
-func (v *Version) populateDomainCheck(domainData []interface{}) (*DomainCheck, error) {
-	d, err := v.populateDomain(domainData[0].(map[string]interface{}))
+// Domain checks come back as an array of the domain, the CNAME it currently
+// points at, and whether that CNAME is correct.
+func (v *Version) populateDomainCheck(checkData []json.RawMessage) (*DomainCheck, error) {
+	if len(checkData) < 3 {
+		err := fmt.Errorf("Domain check data had %d elements, expected 3", len(checkData))
+		return nil, err
+	}
+	var dd domainData
+	if err := json.Unmarshal(checkData[0], &dd); err != nil {
+		return nil, err
+	}
+	d, err := v.populateDomain(&dd)
 	if err != nil {
 		return nil, err
 	}
-	cname, _ := domainData[1].(string)
-	proper, _ := domainData[2].(bool)
+	var cname string
+	var proper bool
+	// either of these may be null
+	json.Unmarshal(checkData[1], &cname)
+	json.Unmarshal(checkData[2], &proper)
 	return &DomainCheck{d, cname, proper}, nil
 }
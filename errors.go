@@ -0,0 +1,65 @@
+package ghastly
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// An APIError is returned when the Fastly API responds with an error status.
+// Msg and Detail are taken from the JSON body of the reply if there was one;
+// the raw body is kept regardless, since not every error (like a 503 from a
+// load balancer) comes back as JSON.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Msg        string
+	Detail     string
+	Body       []byte
+	RequestId  string
+	Header     http.Header
+}
+
+func (e *APIError) Error() string {
+	if e.Msg == "" && e.Detail == "" {
+		return e.Status
+	}
+	return fmt.Sprintf("%s :: %s %s", e.Status, e.Msg, e.Detail)
+}
+
+// Returns true if the error is an API error for a resource that wasn't found.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// Returns true if the error is an API error caused by a conflict with the
+// current state of a resource, like trying to create a domain that already
+// exists.
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+// Returns true if the error is an API error caused by going over Fastly's rate
+// limit.
+func IsRateLimited(err error) bool {
+	return hasStatus(err, http.StatusTooManyRequests)
+}
+
+// Returns true if the error is an API error caused by bad or missing
+// credentials, or by not being permitted to perform the request.
+func IsAuth(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized, http.StatusForbidden)
+}
+
+func hasStatus(err error, codes ...int) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, c := range codes {
+		if apiErr.StatusCode == c {
+			return true
+		}
+	}
+	return false
+}
@@ -3,84 +3,119 @@
 package ghastly
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Client struct {
-	ApiKey    string
-	User      string
-	Password  string
-	BaseUrl   string
-	Http      *http.Client
-	PurgeHttp *http.Client
+	ApiKey       string
+	User         string
+	Password     string
+	BaseUrl      string
+	PurgeBaseUrl string
+	UserAgent    string
+	Http         *http.Client
+	PurgeHttp    *http.Client
+	Logger       *log.Logger
+	// How to retry requests that fail for transient reasons. If nil,
+	// failed requests are not retried.
+	Retry *RetryPolicy
+	// If true, requests that modify things wait for Fastly's rate limit
+	// window to reset once the limit has been used up, rather than
+	// getting a 429 back.
+	Throttle   bool
+	rlMu       sync.Mutex
+	rateLimit  RateLimit
+	middleware []Middleware
+	// Called after the client logs in again because its session expired,
+	// with the error from logging in, if any.
+	OnRelogin func(err error)
+	loginMu   sync.Mutex
+	loginGen  atomic.Uint64
 }
 
 type Ghastly struct {
 	*Client
+	cache atomic.Pointer[lookupCache]
 }
 
-// Initialize a new ghastly object, create the HTTP client, and log in.
+// Initialize a new ghastly object, create the HTTP client, and log in. If
+// opts contains an "api_key", that key is used to authenticate instead and the
+// login step is skipped. The other options understood are "user", "password",
+// "base_url", "purge_base_url", and "user_agent"; anything else is an error.
+// NewFromOptions offers more control.
 func New(opts map[string]string) (*Ghastly, error) {
-	g := &Ghastly{}
-	client, err := login(opts["user"], opts["password"], opts["base_url"])
+	return NewContext(context.Background(), opts)
+}
+
+// Like New, but takes a context.Context for the login request.
+func NewContext(ctx context.Context, opts map[string]string) (*Ghastly, error) {
+	o, err := optionsFromMap(opts)
 	if err != nil {
 		return nil, err
 	}
-	g.Client = client
-	return g, nil
+	return NewFromOptionsContext(ctx, o)
+}
+
+// Initialize a new ghastly object that authenticates with a Fastly API key.
+// The key is sent in the Fastly-Key header with every request, so there is no
+// need to log in and keep a session cookie around.
+func NewWithApiKey(apiKey string, base_url string) (*Ghastly, error) {
+	if apiKey == "" {
+		err := fmt.Errorf("No API key was given")
+		return nil, err
+	}
+	return NewFromOptions(&Options{ApiKey: apiKey, BaseURL: base_url})
 }
 
-func login(username, password string, base_url string) (*Client, error) {
+func (c *Client) login(ctx context.Context, username, password string) error {
 	values := make(url.Values)
 	values.Set("user", username)
 	values.Set("password", password)
-	client := new(Client)
-	if base_url != "" {
-		client.BaseUrl = base_url
-	} else {
-		client.BaseUrl = "https://api.fastly.com"
-	}
-	jar, jerr := cookiejar.New(nil)
-	if jerr != nil {
-		return nil, jerr
-	}
-	client.Http = &http.Client{Jar: jar}
-	resp, err := client.PostForm("/login", values)
+	ctx = context.WithValue(ctx, loggingInKey{}, true)
+	resp, err := c.PostFormContext(ctx, "/login", values)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		err = fmt.Errorf("Error logging in: %s", resp.Status)
-		return nil, err
+		return err
 	}
-	client.PurgeHttp = &http.Client{Jar: client.Http.Jar}
-	purgeURL, _ := url.Parse("https://api.fastly.com/")
-	client.PurgeHttp.Transport = &Transport{Proxy: http.ProxyFromEnvironment, PurgeBaseURL: purgeURL}
-	return client, err
+	return nil
 }
 
 // Convenience wrapper around http.Client.Get.
 func (c *Client) Get(url string) (*http.Response, error) {
-	resp, err := c.Http.Get(c.makeURL(url))
+	return c.GetContext(context.Background(), url)
+}
+
+// Like Get, but takes a context.Context for the request.
+func (c *Client) GetContext(ctx context.Context, url string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", c.makeURL(url), nil)
 	if err != nil {
 		return nil, err
 	}
-	if err = c.checkRespErr(resp); err != nil {
-		return nil, err
-	}
-	return resp, nil
+	return c.do(c.Http, request)
 }
 
 // Convenience wrapper for GET requests with query parameters.
 func (c *Client) GetParams(baseURL string, queryParams map[string]string) (*http.Response, error) {
+	return c.GetParamsContext(context.Background(), baseURL, queryParams)
+}
+
+// Like GetParams, but takes a context.Context for the request.
+func (c *Client) GetParamsContext(ctx context.Context, baseURL string, queryParams map[string]string) (*http.Response, error) {
 	u, err := url.Parse(c.makeURL(baseURL))
 	if err != nil {
 		return nil, err
@@ -90,112 +125,198 @@ func (c *Client) GetParams(baseURL string, queryParams map[string]string) (*http
 		q.Set(k, v)
 	}
 	u.RawQuery = q.Encode()
-	resp, err := c.Http.Get(u.String())
+	request, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	if err = c.checkRespErr(resp); err != nil {
-		return nil, err
-	}
-	return resp, nil
+	return c.do(c.Http, request)
 }
 
 // Convenience wrapper around http.Client.PostForm.
 func (c *Client) PostForm(url string, data url.Values) (*http.Response, error) {
-	resp, err := c.Http.PostForm(c.makeURL(url), data)
-	if err != nil {
-		return nil, err
-	}
-	if err = c.checkRespErr(resp); err != nil {
-		return nil, err
-	}
-	return resp, nil
+	return c.PostFormContext(context.Background(), url, data)
+}
+
+// Like PostForm, but takes a context.Context for the request.
+func (c *Client) PostFormContext(ctx context.Context, url string, data url.Values) (*http.Response, error) {
+	return c.PostContext(ctx, url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
 }
 
 // Post a form to the server with a map[string]string of paramaters.
 func (c *Client) PostFormParams(url string, params map[string]string) (*http.Response, error) {
+	return c.PostFormParamsContext(context.Background(), url, params)
+}
+
+// Like PostFormParams, but takes a context.Context for the request.
+func (c *Client) PostFormParamsContext(ctx context.Context, url string, params map[string]string) (*http.Response, error) {
 	values := c.makeValues(params)
-	resp, err := c.Http.PostForm(c.makeURL(url), values)
-	if err != nil {
-		return nil, err
-	}
-	if err = c.checkRespErr(resp); err != nil {
-		return nil, err
-	}
-	return resp, nil
+	return c.PostFormContext(ctx, url, values)
 }
 
 // Convenience wrapper around http.Client.Post.
 func (c *Client) Post(url string, bodyType string, body io.Reader) (*http.Response, error) {
-	resp, err := c.Http.Post(c.makeURL(url), bodyType, body)
+	return c.PostContext(context.Background(), url, bodyType, body)
+}
+
+// Like Post, but takes a context.Context for the request.
+func (c *Client) PostContext(ctx context.Context, url string, bodyType string, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, "POST", c.makeURL(url), body)
 	if err != nil {
 		return nil, err
 	}
-	if err = c.checkRespErr(resp); err != nil {
-		return nil, err
-	}
-	return resp, nil
+	request.Header.Set("Content-Type", bodyType)
+	return c.do(c.Http, request)
 }
 
 // Convenience wrapper for DELETE requests.
 func (c *Client) Delete(url string, contentType ...string) (*http.Response, error) {
-	request, err := http.NewRequest("DELETE", c.makeURL(url), nil)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := c.Http.Do(request)
+	return c.DeleteContext(context.Background(), url, contentType...)
+}
+
+// Like Delete, but takes a context.Context for the request.
+func (c *Client) DeleteContext(ctx context.Context, url string, contentType ...string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, "DELETE", c.makeURL(url), nil)
 	if err != nil {
 		return nil, err
 	}
-	if err = c.checkRespErr(resp); err != nil {
-		return nil, err
-	}
-	return resp, nil
+	return c.do(c.Http, request)
 }
 
 // Convenience wrapper for PUT requests.
 func (c *Client) Put(url string, data url.Values, contentType ...string) (*http.Response, error) {
+	return c.PutContext(context.Background(), url, data, contentType...)
+}
+
+// Like Put, but takes a context.Context for the request.
+func (c *Client) PutContext(ctx context.Context, url string, data url.Values, contentType ...string) (*http.Response, error) {
 	bodyStr := data.Encode()
-	request, err := http.NewRequest("PUT", c.makeURL(url), strings.NewReader(bodyStr))
-	request.Header.Set("content-type", setContentType(contentType))
-	if err != nil {
-		return nil, err
-	}
-	resp, err := c.Http.Do(request)
+	request, err := http.NewRequestWithContext(ctx, "PUT", c.makeURL(url), strings.NewReader(bodyStr))
 	if err != nil {
 		return nil, err
 	}
-	if err = c.checkRespErr(resp); err != nil {
-		return nil, err
-	}
-	return resp, nil
+	request.Header.Set("content-type", setContentType(contentType))
+	return c.do(c.Http, request)
 }
 
 // Convenience wrapper for PUT requests, taking a map of strings to create the
 // url.Values to send.
 func (c *Client) PutParams(url string, params map[string]string) (*http.Response, error) {
+	return c.PutParamsContext(context.Background(), url, params)
+}
+
+// Like PutParams, but takes a context.Context for the request.
+func (c *Client) PutParamsContext(ctx context.Context, url string, params map[string]string) (*http.Response, error) {
 	values := c.makeValues(params)
-	return c.Put(url, values, "application/x-www-form-urlencoded")
+	return c.PutContext(ctx, url, values, "application/x-www-form-urlencoded")
 }
 
 // Send a PURGE request.
 func (c *Client) Purge(purgeUrl string, contentType ...string) (*http.Response, error) {
-	request, err := http.NewRequest("PURGE", purgeUrl, nil)
-	request.Header.Set("content-type", setContentType(contentType))
-	request.Host = "api.fastly.com"
+	return c.PurgeContext(context.Background(), purgeUrl, contentType...)
+}
+
+// Like Purge, but takes a context.Context for the request. Cancelling the
+// context closes the connection the purge Transport is using for it.
+func (c *Client) PurgeContext(ctx context.Context, purgeUrl string, contentType ...string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, "PURGE", purgeUrl, nil)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.PurgeHttp.Do(request)
+	request.Header.Set("content-type", setContentType(contentType))
+	request.Host = c.purgeHost()
+	return c.do(c.PurgeHttp, request)
+}
+
+// Send a request with the given HTTP client, retrying it according to the
+// client's RetryPolicy if it fails for a transient reason.
+func (c *Client) do(hc *http.Client, request *http.Request) (*http.Response, error) {
+	attempts := c.Retry.maxAttempts(request)
+	relogged := false
+	// Sending a request adds headers to it, like cookies, so every try
+	// gets a fresh copy of the original.
+	orig := request.Clone(request.Context())
+	for attempt := 1; ; attempt++ {
+		gen := c.loginGen.Load()
+		resp, err := c.send(hc, request)
+		if err != nil && !relogged && c.canRelogin(request, err) {
+			// The session's probably expired. Log in again and
+			// replay the request, without counting it as a retry.
+			relogged = true
+			if lerr := c.relogin(request.Context(), gen); lerr != nil {
+				return nil, err
+			}
+			if request, err = copyRequest(orig); err != nil {
+				return nil, err
+			}
+			attempt--
+			continue
+		}
+		if err == nil || attempt >= attempts || !isRetryable(err) {
+			return resp, err
+		}
+		wait := c.Retry.backoff(attempt, err)
+		c.logf("retrying %s %s in %v after error: %s", request.Method, request.URL, wait, err)
+		if serr := sleepContext(request.Context(), wait); serr != nil {
+			serr = fmt.Errorf("%w (while waiting to retry after: %s)", serr, err)
+			return nil, serr
+		}
+		if request, err = copyRequest(orig); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Make a copy of the request, with a fresh body, so it can be sent again.
+func copyRequest(request *http.Request) (*http.Request, error) {
+	r := request.Clone(request.Context())
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return r, nil
+}
+
+// Send a request once, adding the API key header if the client is using key
+// authentication, and check the response for errors. Rate limit headers in the
+// response are recorded on the client.
+func (c *Client) send(hc *http.Client, request *http.Request) (*http.Response, error) {
+	if c.ApiKey != "" {
+		request.Header.Set("Fastly-Key", c.ApiKey)
+	}
+	if c.UserAgent != "" {
+		request.Header.Set("User-Agent", c.UserAgent)
+	}
+	if err := c.throttle(request.Context(), request.Method); err != nil {
+		return nil, err
+	}
+	resp, err := c.roundTripper(hc)(request)
 	if err != nil {
 		return nil, err
 	}
+	c.recordRateLimit(resp.Header)
 	if err = c.checkRespErr(resp); err != nil {
 		return nil, err
 	}
 	return resp, nil
 }
 
+// The Host header to send with PURGE requests.
+func (c *Client) purgeHost() string {
+	if u, err := url.Parse(c.PurgeBaseUrl); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "api.fastly.com"
+}
+
+func (c *Client) logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+	}
+}
+
 func setContentType(contentType []string) string {
 	var cType string
 	if contentType != nil {
@@ -224,13 +345,24 @@ func (c *Client) makeValues(params map[string]string) url.Values {
 
 func (c *Client) checkRespErr(resp *http.Response) error {
 	if resp.StatusCode >= 400 {
-		rdata, err := ParseJson(resp.Body)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
 		if err != nil {
 			return err
 		}
-		detail, _ := rdata["detail"].(string)
-		err = fmt.Errorf("%s :: %s %s", resp.Status, rdata["msg"].(string), detail)
-		return err
+		apiErr := &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: body, Header: resp.Header}
+		apiErr.RequestId = resp.Header.Get("Fastly-Request-Id")
+		if apiErr.RequestId == "" {
+			apiErr.RequestId = resp.Header.Get("X-Request-Id")
+		}
+		// The body isn't always JSON, so any problems decoding it
+		// are ignored and the raw body is left for the caller.
+		var rdata map[string]interface{}
+		if json.Unmarshal(body, &rdata) == nil {
+			apiErr.Msg, _ = rdata["msg"].(string)
+			apiErr.Detail, _ = rdata["detail"].(string)
+		}
+		return apiErr
 	}
 	return nil
 }
@@ -252,3 +384,36 @@ func ParseJsonArray(data io.ReadCloser) ([]interface{}, error) {
 	}
 	return respData, nil
 }
+
+// Like ParseJson, but decodes the JSON into a value of type T, which will
+// usually be a struct with json tags. The data is closed afterwards.
+func ParseJsonTyped[T any](data io.ReadCloser) (T, error) {
+	defer data.Close()
+	var respData T
+	dec := json.NewDecoder(data)
+	if err := dec.Decode(&respData); err != nil {
+		return respData, err
+	}
+	return respData, nil
+}
+
+// Like ParseJsonArray, but decodes the JSON array into a slice of type T. The
+// data is closed afterwards.
+func ParseJsonArrayTyped[T any](data io.ReadCloser) ([]T, error) {
+	defer data.Close()
+	respData := make([]T, 0)
+	dec := json.NewDecoder(data)
+	if err := dec.Decode(&respData); err != nil {
+		return nil, err
+	}
+	return respData, nil
+}
+
+// Parse a timestamp from the API. Missing timestamps come back as the zero
+// time.
+func parseTime(t string) (time.Time, error) {
+	if t == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, t)
+}
@@ -1,23 +1,78 @@
 package ghastly
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/ctdk/ghastly/ghastlytest"
 )
 
 var G *Ghastly
 var S *Service
 
+// The fake Fastly API the tests run against, unless FASTLY_TEST_USER and
+// FASTLY_TEST_PASSWORD are set to run them against the real thing.
+var fake *ghastlytest.Server
+
+func TestMain(m *testing.M) {
+	if os.Getenv("FASTLY_TEST_USER") == "" {
+		fake = ghastlytest.NewServer()
+	}
+	code := m.Run()
+	if fake != nil {
+		fake.Close()
+	}
+	os.Exit(code)
+}
+
+func testLoginOpts() map[string]string {
+	if fake != nil {
+		return fake.LoginOpts()
+	}
+	login_opts := make(map[string]string)
+	login_opts["user"] = os.Getenv("FASTLY_TEST_USER")
+	login_opts["password"] = os.Getenv("FASTLY_TEST_PASSWORD")
+	return login_opts
+}
+
+// Options with no credentials, pointing at whichever API the tests use.
+func testBaseOpts() map[string]string {
+	opts := make(map[string]string)
+	if fake != nil {
+		opts["base_url"] = fake.URL
+		opts["purge_base_url"] = fake.URL
+	}
+	return opts
+}
+
+func testApiKey() string {
+	if fake != nil {
+		return fake.ApiKey
+	}
+	return os.Getenv("FASTLY_TEST_API_KEY")
+}
+
 func makeServiceName() string {
 	return fmt.Sprintf("ghastly-test-%d-%d", os.Getpid(), rand.Int31())
 }
 
 func TestBadGhastlyLogin(t *testing.T) {
 	// no username/pass
-	login_opts := make(map[string]string)
+	login_opts := testBaseOpts()
 	_, err := New(login_opts)
 	if err == nil {
 		t.Errorf("Logging into fastly unexpectedly succeeded with no username or password.")
@@ -32,20 +87,384 @@ func TestBadGhastlyLogin(t *testing.T) {
 }
 
 func TestGhastlyLogin(t *testing.T) {
-	login_opts := make(map[string]string)
-	login_opts["user"] = os.Getenv("FASTLY_TEST_USER")
-	login_opts["password"] = os.Getenv("FASTLY_TEST_PASSWORD")
+	login_opts := testLoginOpts()
 	_, err := New(login_opts)
 	if err != nil {
 		t.Errorf("Error logging into fastly: %s", err.Error())
 	}
 }
 
+func TestGhastlyApiKey(t *testing.T) {
+	_, err := NewWithApiKey("", "")
+	if err == nil {
+		t.Errorf("Creating a client with an empty API key unexpectedly succeeded.")
+	}
+	apiKey := testApiKey()
+	if apiKey == "" {
+		t.Skip("FASTLY_TEST_API_KEY is not set")
+	}
+	g, err := NewWithApiKey(apiKey, testBaseOpts()["base_url"])
+	if err != nil {
+		t.Fatalf("Error creating API key client: %s", err.Error())
+	}
+	_, err = g.ListServices()
+	if err != nil {
+		t.Errorf("Error listing services with an API key: %s", err.Error())
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	c := new(Client)
+	resp := &http.Response{StatusCode: 404, Status: "404 Not Found", Header: make(http.Header)}
+	resp.Header.Set("Fastly-Request-Id", "abc123")
+	resp.Body = io.NopCloser(strings.NewReader(`{"msg":"Record not found","detail":"Couldn't find Service 'foo'"}`))
+	err := c.checkRespErr(resp)
+	if !IsNotFound(err) {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected an *APIError, got %T", err)
+	}
+	if apiErr.Msg != "Record not found" || apiErr.RequestId != "abc123" {
+		t.Errorf("APIError fields were not filled in correctly: %+v", apiErr)
+	}
+	// HTML bodies from load balancers shouldn't cause any trouble
+	resp = &http.Response{StatusCode: 503, Status: "503 Service Unavailable", Header: make(http.Header)}
+	resp.Body = io.NopCloser(strings.NewReader("<html><body>No server is available</body></html>"))
+	err = c.checkRespErr(resp)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 503 {
+		t.Errorf("Expected a 503 *APIError, got %v", err)
+	}
+	if IsNotFound(err) || IsAuth(err) || IsConflict(err) || IsRateLimited(err) {
+		t.Errorf("The 503 error matched a helper it shouldn't have")
+	}
+}
+
+func TestPurgeContextCancel(t *testing.T) {
+	hang := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer ts.Close()
+	defer close(hang)
+	purgeURL, _ := url.Parse(ts.URL)
+	c := &Client{PurgeHttp: &http.Client{Transport: &Transport{PurgeBaseURL: purgeURL}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.PurgeContext(ctx, "http://localhost/img.png")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the purge to time out, got %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var tries int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tries++
+		if tries%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer ts.Close()
+	c := &Client{BaseUrl: ts.URL, Http: &http.Client{}}
+	c.Retry = &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	if _, err := c.Get("/service"); err != nil {
+		t.Errorf("GET should have been retried until it succeeded, got %v", err)
+	}
+	if tries != 3 {
+		t.Errorf("Expected 3 tries, got %d", tries)
+	}
+	tries = 0
+	if _, err := c.PostFormParams("/service", map[string]string{"name": "foo"}); err == nil {
+		t.Errorf("POST was unexpectedly retried without opting in")
+	}
+	if tries != 1 {
+		t.Errorf("Expected 1 try for a POST, got %d", tries)
+	}
+	tries = 0
+	if _, err := c.PostFormParamsContext(RetryPost(context.Background()), "/service", map[string]string{"name": "foo"}); err != nil {
+		t.Errorf("POST should have been retried after opting in, got %v", err)
+	}
+	// giving up while waiting to retry is reported as the context's error
+	tries = 0
+	c.Retry = &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Second, MaxBackoff: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetContext(ctx, "/service"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded while waiting to retry, got %v", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Unix()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Fastly-RateLimit-Remaining", "0")
+		w.Header().Set("Fastly-RateLimit-Reset", fmt.Sprintf("%d", reset))
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer ts.Close()
+	c := &Client{BaseUrl: ts.URL, Http: &http.Client{}, Throttle: true}
+	if _, err := c.PostFormParams("/service", nil); err != nil {
+		t.Fatal(err)
+	}
+	rl := c.RateLimit()
+	if rl.Remaining != 0 || rl.Reset.Unix() != reset {
+		t.Errorf("Rate limit state wasn't recorded properly: %+v", rl)
+	}
+	// reading shouldn't be held up, but the next POST should be
+	if _, err := c.Get("/service"); err != nil {
+		t.Errorf("GET was unexpectedly throttled: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.PostFormParamsContext(ctx, "/service", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the POST to wait for the rate limit to reset, got %v", err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	_, err := New(map[string]string{"api_key": "abc", "baseurl": "http://localhost"})
+	if err == nil {
+		t.Errorf("An unknown option was unexpectedly accepted.")
+	}
+	if _, err = NewFromOptions(nil); err == nil {
+		t.Errorf("Nil options were unexpectedly accepted.")
+	}
+	if _, err = NewFromOptions(&Options{Password: "pass", BaseURL: "http://localhost:1"}); err == nil || strings.Contains(err.Error(), "connect") {
+		t.Errorf("Options with no API key or user should fail before logging in, got %v", err)
+	}
+	var purgeHost, userAgent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		purgeHost = r.Host
+		userAgent = r.Header.Get("User-Agent")
+		w.Write([]byte(`{"status":"ok","id":"108-1391560174-974124"}`))
+	}))
+	defer ts.Close()
+	g, err := NewFromOptions(&Options{ApiKey: "abc", BaseURL: ts.URL, PurgeBaseURL: ts.URL, UserAgent: "ghastly-test", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := g.PurgeURL("http://localhost/img.png")
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if pid != "108-1391560174-974124" {
+		t.Errorf("Got the wrong purge id back: %s", pid)
+	}
+	if purgeHost != strings.TrimPrefix(ts.URL, "http://") {
+		t.Errorf("The purge was sent with the wrong host, %s", purgeHost)
+	}
+	if userAgent != "ghastly-test" {
+		t.Errorf("The user agent was not set, got %s", userAgent)
+	}
+}
+
+func TestTypedDecoding(t *testing.T) {
+	g := &Ghastly{Client: new(Client)}
+	body := `{"id":"SU1Z0isxPaozGVKXdv0eY","name":"test","customer_id":null,"comment":null,
+		"created_at":"2013-05-01T10:00:00Z","updated_at":"2013-05-02T10:00:00Z",
+		"versions":[{"number":1,"active":false},{"number":2,"active":true,"network":"staging"}]}`
+	sData, err := ParseJsonTyped[serviceData](io.NopCloser(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := g.populateService(&sData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ActiveVersion != 2 {
+		t.Errorf("Expected active version 2, got %d", s.ActiveVersion)
+	}
+	if s.UpdatedAt.Equal(s.CreatedAt) {
+		t.Errorf("UpdatedAt was the same as CreatedAt: %v", s.UpdatedAt)
+	}
+	v, err := s.populateVersion(&sData.Versions[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Network.Name != "staging" || v.ServiceId != s.Id {
+		t.Errorf("Version was not decoded properly: %+v", v)
+	}
+	// with a list of versions, "version" isn't taken to be the active one
+	sData.Version = 2
+	sData.Versions[1].Active = false
+	if s, err = g.populateService(&sData); err != nil {
+		t.Fatal(err)
+	}
+	if s.ActiveVersion != 0 {
+		t.Errorf("Expected no active version, got %d", s.ActiveVersion)
+	}
+	// a service with no id is an error, not a panic
+	if _, err = g.populateService(&serviceData{Name: "noid"}); err == nil {
+		t.Errorf("A service with no id was unexpectedly accepted")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Audit") != "yes" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		http.SetCookie(w, &http.Cookie{Name: "fastly.session", Value: "sekrit-session"})
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer ts.Close()
+	buf := new(strings.Builder)
+	var seen []string
+	audit := func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			req.Header.Set("X-Audit", "yes")
+			seen = append(seen, req.Method+" "+req.URL.Path)
+			return next(req)
+		}
+	}
+	opts := &Options{User: "user@example.com", Password: "sekrit-password", BaseURL: ts.URL}
+	opts.Middleware = []Middleware{audit, LoggingMiddleware(log.New(buf, "", 0))}
+	g, err := NewFromOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.ApiKey = "sekrit-key"
+	if _, err = g.Get("/service"); err != nil {
+		t.Errorf(err.Error())
+	}
+	if len(seen) != 2 || seen[0] != "POST /login" {
+		t.Errorf("The middleware did not see the expected requests: %v", seen)
+	}
+	logged := buf.String()
+	for _, secret := range []string{"sekrit-password", "sekrit-key", "sekrit-session"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("%s was not masked in the log:\n%s", secret, logged)
+		}
+	}
+	if !strings.Contains(logged, "GET "+ts.URL+"/service") {
+		t.Errorf("The GET request was not logged:\n%s", logged)
+	}
+
+	// backend client keys are masked in form bodies
+	buf.Reset()
+	params, err := encodeParams(&BackendInput{Name: String("origin"), SslClientCert: String("public-cert"), SslClientKey: String("sekrit-client-key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.PostForm("/service/x/version/1/backend", params); err != nil {
+		t.Errorf(err.Error())
+	}
+	logged = buf.String()
+	if strings.Contains(logged, "sekrit-client-key") || !strings.Contains(logged, "ssl_client_key="+redacted) {
+		t.Errorf("The client key was not masked in the log:\n%s", logged)
+	}
+	if !strings.Contains(logged, "public-cert") {
+		t.Errorf("The rest of the form body was not logged:\n%s", logged)
+	}
+}
+
+func TestRelogin(t *testing.T) {
+	var mu sync.Mutex
+	session, logins, flaky := "first", 0, true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/login" {
+			logins++
+			http.SetCookie(w, &http.Cookie{Name: "fastly.session", Value: session})
+			w.Write([]byte(`{}`))
+			return
+		}
+		if r.URL.Path == "/forbidden" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"msg":"You are not permitted to do that"}`))
+			return
+		}
+		if r.URL.Path == "/flaky" && flaky {
+			flaky = false
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"msg":"Provided credentials are missing or invalid"}`))
+			return
+		}
+		if c, err := r.Cookie("fastly.session"); err != nil || c.Value != session {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"msg":"Provided credentials are missing or invalid"}`))
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer ts.Close()
+	var relogins int32
+	opts := &Options{User: "user@example.com", Password: "pass", BaseURL: ts.URL}
+	opts.OnRelogin = func(err error) {
+		if err != nil {
+			t.Errorf("Logging in again failed: %s", err)
+		}
+		atomic.AddInt32(&relogins, 1)
+	}
+	g, err := NewFromOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// expire the session
+	mu.Lock()
+	session = "second"
+	mu.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := g.PostFormParams("/service/foo/purge/bar", nil); err != nil {
+				t.Errorf("Request was not replayed after logging in again: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if relogins != 1 || logins != 2 {
+		t.Errorf("Expected to log in again exactly once, got %d relogins and %d logins", relogins, logins)
+	}
+
+	// a 403 isn't an expired session
+	if _, err := g.Get("/forbidden"); !IsAuth(err) {
+		t.Errorf("Expected a 403 error, got %v", err)
+	}
+	if logins != 2 {
+		t.Errorf("A 403 caused a login")
+	}
+
+	// the hook can make requests, even ones that need another login
+	var hookCalls int32
+	g.OnRelogin = func(err error) {
+		if atomic.AddInt32(&hookCalls, 1) == 1 {
+			if _, err := g.Get("/flaky"); err != nil {
+				t.Errorf("Request from the relogin hook failed: %s", err)
+			}
+		}
+	}
+	mu.Lock()
+	session = "third"
+	mu.Unlock()
+	done := make(chan error)
+	go func() {
+		_, err := g.Get("/service/foo")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Request was not replayed after logging in again: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Logging in again deadlocked when the hook made a request")
+	}
+	if hookCalls != 2 || logins != 4 {
+		t.Errorf("Expected 2 hook calls and 4 logins, got %d and %d", hookCalls, logins)
+	}
+}
+
 func TestService(t *testing.T) {
 	rand.Seed(time.Now().UnixNano())
-	login_opts := make(map[string]string)
-	login_opts["user"] = os.Getenv("FASTLY_TEST_USER")
-	login_opts["password"] = os.Getenv("FASTLY_TEST_PASSWORD")
+	login_opts := testLoginOpts()
 	g, err := New(login_opts)
 	if err != nil {
 		t.Errorf("Error logging into fastly: %s", err.Error())
@@ -69,9 +488,7 @@ func TestService(t *testing.T) {
 }
 
 func TestServiceDetail(t *testing.T) {
-	login_opts := make(map[string]string)
-	login_opts["user"] = os.Getenv("FASTLY_TEST_USER")
-	login_opts["password"] = os.Getenv("FASTLY_TEST_PASSWORD")
+	login_opts := testLoginOpts()
 	g, err := New(login_opts)
 	if err != nil {
 		t.Errorf("Error logging into fastly: %s", err.Error())
@@ -93,15 +510,35 @@ func TestServiceDetail(t *testing.T) {
 	if s2.Id != s3.Id {
 		t.Errorf("The normal and detailed services were not the same service.")
 	}
-	if s2.CreatedAt == s3.CreatedAt {
-		t.Errorf("The detailed service does not seem to actually have different details.")
+	if s3.CreatedAt.IsZero() || !s2.CreatedAt.Equal(s3.CreatedAt) {
+		t.Errorf("The normal and detailed services had different creation times: %s and %s", s2.CreatedAt, s3.CreatedAt)
+	}
+	if len(s3.Versions) == 0 || s3.Current == nil {
+		t.Fatalf("The detailed service did not have its versions")
+	}
+	if s3.Current.Number != s3.Versions[len(s3.Versions)-1].Number {
+		t.Errorf("The current version was %d, expected %d", s3.Current.Number, s3.Versions[len(s3.Versions)-1].Number)
+	}
+	_, err = s3.Current.NewDomain(&DomainInput{Name: String("details.fnerpherder.com")})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if err = s3.Current.Activate(); err != nil {
+		t.Errorf(err.Error())
+	}
+	s4, err := s2.Details()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s4.Active == nil || s4.Active.Number != s3.Current.Number || s2.ActiveVersion != s3.Current.Number {
+		t.Errorf("The active version in the details was not right: %+v", s4.Active)
+	} else if len(s4.Active.Domains) != 1 || s4.Active.Domains[0].Name != "details.fnerpherder.com" {
+		t.Errorf("The active version's domains were not in the details: %v", s4.Active.Domains)
 	}
 }
 
 func TestListServices(t *testing.T) {
-	login_opts := make(map[string]string)
-	login_opts["user"] = os.Getenv("FASTLY_TEST_USER")
-	login_opts["password"] = os.Getenv("FASTLY_TEST_PASSWORD")
+	login_opts := testLoginOpts()
 	g, err := New(login_opts)
 	if err != nil {
 		t.Errorf("Error logging into fastly: %s", err.Error())
@@ -122,9 +559,7 @@ func TestListServices(t *testing.T) {
 }
 
 func TestSearchServices(t *testing.T) {
-	login_opts := make(map[string]string)
-	login_opts["user"] = os.Getenv("FASTLY_TEST_USER")
-	login_opts["password"] = os.Getenv("FASTLY_TEST_PASSWORD")
+	login_opts := testLoginOpts()
 	g, err := New(login_opts)
 	if err != nil {
 		t.Errorf("Error logging into fastly: %s", err.Error())
@@ -146,10 +581,57 @@ func TestSearchServices(t *testing.T) {
 	}
 }
 
+func TestQueryServices(t *testing.T) {
+	login_opts := testLoginOpts()
+	g, err := New(login_opts)
+	if err != nil {
+		t.Errorf("Error logging into fastly: %s", err.Error())
+	}
+	prefix := fmt.Sprintf("ghastly-query-%d", os.Getpid())
+	for _, suffix := range []string{"prod-web", "prod-api", "dev-web"} {
+		s, err := g.NewService(prefix + "-" + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Delete()
+	}
+	count := func(q *ServiceQuery) int {
+		n := 0
+		for _, err := range g.QueryServices(q) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			n++
+		}
+		return n
+	}
+	if n := count(&ServiceQuery{NameGlob: prefix + "-*", PageSize: 2}); n != 3 {
+		t.Errorf("Expected 3 services matching the glob, got %d", n)
+	}
+	if n := count(&ServiceQuery{NameRegexp: regexp.MustCompile("^" + prefix + "-prod-"), UpdatedSince: time.Now().Add(-time.Hour)}); n != 2 {
+		t.Errorf("Expected 2 prod services matching the regexp, got %d", n)
+	}
+	if n := count(&ServiceQuery{NameGlob: prefix + "-*", HasActiveVersion: true}); n != 0 {
+		t.Errorf("Expected no active services, got %d", n)
+	}
+	for s, err := range g.QueryServices(&ServiceQuery{NameGlob: prefix + "-*-web", PageSize: 1}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Name != prefix+"-prod-web" {
+			t.Errorf("Expected the first web service to be %s-prod-web, got %s", prefix, s.Name)
+		}
+		break
+	}
+	for _, err := range g.QueryServices(&ServiceQuery{NameGlob: "[bad"}) {
+		if err == nil {
+			t.Errorf("A bad glob was unexpectedly accepted")
+		}
+	}
+}
+
 func TestUpdateService(t *testing.T) {
-	login_opts := make(map[string]string)
-	login_opts["user"] = os.Getenv("FASTLY_TEST_USER")
-	login_opts["password"] = os.Getenv("FASTLY_TEST_PASSWORD")
+	login_opts := testLoginOpts()
 	g, err := New(login_opts)
 	if err != nil {
 		t.Errorf("Error logging into fastly: %s", err.Error())
@@ -162,8 +644,7 @@ func TestUpdateService(t *testing.T) {
 	}
 	defer s1.Delete()
 	s2, _ := g.GetService(s1.Id)
-	params := map[string]string{"name": serviceName2}
-	err = s2.Update(params)
+	err = s2.Update(&ServiceInput{Name: String(serviceName2)})
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -174,13 +655,159 @@ func TestUpdateService(t *testing.T) {
 	if s3.Name == s1.Name {
 		t.Errorf("Service name did not update at source, expected %s, got %s", serviceName2, s3.Name)
 	}
+	// only the comment should change
+	err = s2.Update(&ServiceInput{Comment: String("a comment")})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if s2.Name != serviceName2 || s2.Comment != "a comment" {
+		t.Errorf("Expected name %s and comment 'a comment', got %s and '%s'", serviceName2, s2.Name, s2.Comment)
+	}
+	if err = s2.Update(&ServiceInput{Name: String("")}); err == nil {
+		t.Errorf("Blanking the service name should have returned an error")
+	}
+
+	s4, err := g.NewServiceWithInput(&ServiceInput{Name: String(makeServiceName()), Comment: String("made with a comment")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s4.Delete()
+	if s4.Comment != "made with a comment" {
+		t.Errorf("Expected the new service's comment to be 'made with a comment', got '%s'", s4.Comment)
+	}
+}
+
+func TestCache(t *testing.T) {
+	g, err := New(testLoginOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.EnableCache(time.Minute, 3)
+	serviceName := makeServiceName()
+	s, err := g.NewService(serviceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Delete()
+	s1, err := g.GetService(s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := g.GetService(s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s1 != s2 {
+		t.Errorf("The second lookup should have come from the cache")
+	}
+	if s3, _ := g.SearchServices(serviceName); s3 != s1 {
+		t.Errorf("Searching by name should have come from the cache")
+	}
+	stats := g.CacheStats()
+	if stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("Expected 2 hits and 1 miss, got %d and %d", stats.Hits, stats.Misses)
+	}
+	if r := stats.HitRate(); r < 0.66 || r > 0.67 {
+		t.Errorf("Expected a hit rate of 2/3, got %f", r)
+	}
+
+	v, err := s1.NewVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, _ := s1.GetVersion(v.Number)
+	hits := g.CacheStats().Hits
+	if v2, _ := s1.GetVersion(v.Number); v2.Number != v1.Number || g.CacheStats().Hits != hits+1 {
+		t.Errorf("The version should have come from the cache")
+	}
+	if _, err := v1.Clone(); err != nil {
+		t.Fatal(err)
+	}
+	if s4, _ := g.GetService(s.Id); s4 == s1 {
+		t.Errorf("Cloning a version should have invalidated the service")
+	} else if len(s4.cachedVersions()) != 3 {
+		t.Errorf("Expected the refetched service to have 3 versions, got %d", len(s4.cachedVersions()))
+	}
+
+	if err := v1.Activate(); err != nil {
+		t.Fatal(err)
+	}
+	s5, _ := g.GetService(s.Id)
+	if s5.ActiveVersion != v1.Number {
+		t.Errorf("Expected active version %d after activation, got %d", v1.Number, s5.ActiveVersion)
+	}
+	if v3, _ := s1.GetVersion(v.Number); !v3.Active {
+		t.Errorf("The cached version should have been refreshed after activation")
+	}
+	if err := v1.Deactivate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a cached version belongs to the service handle it's looked up through
+	first, _ := g.GetService(s.Id)
+	first.GetVersion(v.Number)
+	other, err := s.GetVersion(v.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Activate(); err != nil {
+		t.Fatal(err)
+	}
+	if s.ActiveVersion != v.Number {
+		t.Errorf("Activating through the second handle should have set its active version to %d, got %d", v.Number, s.ActiveVersion)
+	}
+	if first.ActiveVersion != 0 {
+		t.Errorf("Activating through the second handle changed the first handle's active version")
+	}
+	if err := other.Deactivate(); err != nil {
+		t.Fatal(err)
+	}
+
+	newName := makeServiceName()
+	if err := s5.Update(&ServiceInput{Name: String(newName)}); err != nil {
+		t.Fatal(err)
+	}
+	if s6, _ := g.GetService(s.Id); s6.Name != newName {
+		t.Errorf("Expected the service name to be %s after an update, got %s", newName, s6.Name)
+	}
+
+	g.ClearCache()
+	if n := g.CacheStats().Entries; n != 0 {
+		t.Errorf("Expected an empty cache, got %d entries", n)
+	}
+	g.DisableCache()
+	if s7, _ := g.GetService(s.Id); s7 == s1 {
+		t.Errorf("Lookups shouldn't be cached with the cache off")
+	}
+
+	// a service is cached under its id and name, so one of them won't fit
+	g.EnableCache(time.Minute, 1)
+	g.GetService(s.Id)
+	if stats := g.CacheStats(); stats.Evictions != 1 || stats.Entries != 1 {
+		t.Errorf("Expected 1 eviction and 1 entry, got %d and %d", stats.Evictions, stats.Entries)
+	}
+
+	// switching the cache on and off while looking things up is safe
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			g.DisableCache()
+			g.EnableCache(time.Minute, 10)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := g.GetService(s.Id); err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Wait()
 }
 
 // one service from here on out
 func TestSetupService(t *testing.T) {
-	login_opts := make(map[string]string)
-	login_opts["user"] = os.Getenv("FASTLY_TEST_USER")
-	login_opts["password"] = os.Getenv("FASTLY_TEST_PASSWORD")
+	login_opts := testLoginOpts()
 	var err error
 	G, err = New(login_opts)
 	if err != nil {
@@ -215,8 +842,7 @@ func TestDomain(t *testing.T) {
 		t.Errorf(err.Error())
 	}
 	domainName := "oiweruaklsjfas.com"
-	domainParams := map[string]string{"name": domainName}
-	d, err := v.NewDomain(domainParams)
+	d, err := v.NewDomain(&DomainInput{Name: String(domainName)})
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -238,20 +864,28 @@ func TestDomain(t *testing.T) {
 	if err != nil {
 		t.Errorf(err.Error())
 	}
-	domainParams["name"] = "www.fnerpherder.com"
-	d3, err := v.NewDomain(domainParams)
+	d3, err := v.NewDomain(&DomainInput{Name: String("www.fnerpherder.com")})
 	if err != nil {
 		t.Errorf(err.Error())
 	}
-	domainParams["name"] = "img.fnerpherder.com"
-	domainParams["comment"] = "a comment"
-	d3.Update(domainParams)
-	d4, _ := v.GetDomain(domainParams["name"])
+	err = d3.Update(&DomainInput{Name: String("img.fnerpherder.com"), Comment: String("a comment")})
 	if err != nil {
 		t.Errorf(err.Error())
 	}
-	if d4.Comment != domainParams["comment"] {
-		t.Errorf("Domain comment did not update. Expected '%s', got '%s'", domainParams["comment"], d4.Comment)
+	d4, err := v.GetDomain("img.fnerpherder.com")
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if d4.Comment != "a comment" {
+		t.Errorf("Domain comment did not update. Expected 'a comment', got '%s'", d4.Comment)
+	}
+	// updating only the comment shouldn't touch the name
+	err = d3.Update(&DomainInput{Comment: String("another comment")})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if d3.Name != "img.fnerpherder.com" || d3.Comment != "another comment" {
+		t.Errorf("Expected img.fnerpherder.com with 'another comment', got %s with '%s'", d3.Name, d3.Comment)
 	}
 	dc, err := v.CheckDomain(d4.Name)
 	if err != nil {
@@ -289,24 +923,814 @@ func TestDomain(t *testing.T) {
 		t.Errorf("Got the wrong name back with the listed domain, got %s expected %s", listAll[0].Name, d4.Name)
 	}
 	d4.Delete()
-}
 
-func TestPurge(t *testing.T) {
-	pid, err := G.PurgeURL("http://localhost/img.png")
+	// names are escaped in paths
+	odd := "odd/name%.fnerpherder.com"
+	od, err := v.NewDomain(&DomainInput{Name: String(odd)})
 	if err != nil {
-		t.Errorf(err.Error())
+		t.Fatal(err)
 	}
-	if pid == "" {
-		t.Errorf("Purge id after purging was unexpectedly nil")
+	if od2, err := v.GetDomain(odd); err != nil || od2.Name != odd {
+		t.Errorf("Getting a domain named %q failed: %v", odd, err)
+	}
+	if odc, err := v.CheckDomain(odd); err != nil || odc.Name != odd {
+		t.Errorf("Checking a domain named %q failed: %v", odd, err)
+	}
+	if err = od.Update(&DomainInput{Comment: String("odd")}); err != nil || od.Comment != "odd" {
+		t.Errorf("Updating a domain named %q failed: %v", odd, err)
+	}
+	if err = od.Delete(); err != nil {
+		t.Errorf("Deleting a domain named %q failed: %v", odd, err)
 	}
+}
 
-	// Waiting for being able to activate versions for this
-	/*
-		err = S.PurgeAll()
-		if err != nil {
-			t.Errorf(err.Error())
-		}
-	*/
+func TestBackend(t *testing.T) {
+	v, err := S.NewVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := v.NewBackend(&BackendInput{Name: String("origin"), Address: String("origin.fnerpherder.com"), UseSsl: Bool(true), SslCertHostname: String("origin.fnerpherder.com"), Port: Int(443)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Address != "origin.fnerpherder.com" || b.Port != 443 || !b.UseSsl {
+		t.Errorf("Created backend didn't match: got %s:%d, use_ssl %v", b.Address, b.Port, b.UseSsl)
+	}
+	if b.Version != v.Number {
+		t.Errorf("Created backend version did not match, expected %d, got %d", v.Number, b.Version)
+	}
+	if _, err := v.NewBackend(&BackendInput{Name: String("no-address")}); err == nil {
+		t.Errorf("Creating a backend with no address should have failed")
+	}
+	err = b.Update(&BackendInput{ConnectTimeout: Int(5000), Shield: String("iad-va-us"), Weight: Int(50)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Name != "origin" || b.ConnectTimeout != 5000 || b.Shield != "iad-va-us" || b.Weight != 50 {
+		t.Errorf("Backend didn't update as expected: %+v", b)
+	}
+	if b.Address != "origin.fnerpherder.com" || b.SslCertHostname != "origin.fnerpherder.com" {
+		t.Errorf("Updating some backend fields changed others: %+v", b)
+	}
+	b2, err := v.GetBackend("origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b2.ConnectTimeout != 5000 || b2.Port != 443 {
+		t.Errorf("Backend didn't update at the source: %+v", b2)
+	}
+	if _, err := v.NewBackend(&BackendInput{Name: String("second"), Address: String("192.0.2.10")}); err != nil {
+		t.Fatal(err)
+	}
+	backends, err := v.ListBackends()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backends) != 2 {
+		t.Errorf("Expected 2 backends, got %d", len(backends))
+	}
+	if err = b.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = v.GetBackend("origin"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a deleted backend, got %v", err)
+	}
+
+	// names are escaped in paths
+	odd := "50% of /api?x #1"
+	ob, err := v.NewBackend(&BackendInput{Name: String(odd), Address: String("192.0.2.11")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ob2, err := v.GetBackend(odd); err != nil || ob2.Name != odd {
+		t.Errorf("Getting a backend named %q failed: %v", odd, err)
+	}
+	if err = ob.Update(&BackendInput{Port: Int(8080)}); err != nil || ob.Port != 8080 {
+		t.Errorf("Updating a backend named %q failed: %v", odd, err)
+	}
+	if err = ob.Delete(); err != nil {
+		t.Errorf("Deleting a backend named %q failed: %v", odd, err)
+	}
+}
+
+func TestHealthcheck(t *testing.T) {
+	v, err := S.NewVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := v.NewHealthcheck(&HealthcheckInput{Name: String("health"), Path: String("/status"), Host: String("origin.fnerpherder.com"), Method: String("GET")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Path != "/status" || h.Method != "GET" || h.ExpectedResponse != 200 {
+		t.Errorf("Created healthcheck didn't match: %+v", h)
+	}
+	err = h.Update(&HealthcheckInput{CheckInterval: Int(15000), Threshold: Int(2), Window: Int(3), Initial: Int(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.CheckInterval != 15000 || h.Threshold != 2 || h.Window != 3 || h.Initial != 1 || h.Path != "/status" {
+		t.Errorf("Healthcheck didn't update as expected: %+v", h)
+	}
+	h2, err := v.GetHealthcheck("health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h2.CheckInterval != 15000 {
+		t.Errorf("Healthcheck didn't update at the source: %+v", h2)
+	}
+
+	b, err := v.NewBackend(&BackendInput{Name: String("checked"), Address: String("192.0.2.2"), Healthcheck: String("health")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Healthcheck != "health" {
+		t.Errorf("Expected the backend's healthcheck to be 'health', got '%s'", b.Healthcheck)
+	}
+	if _, err = v.NewBackend(&BackendInput{Name: String("unchecked"), Address: String("192.0.2.3"), Healthcheck: String("missing")}); err == nil {
+		t.Errorf("Creating a backend with a missing healthcheck should have failed")
+	}
+	if err = b.Update(&BackendInput{Healthcheck: String("missing")}); err == nil {
+		t.Errorf("Updating a backend to a missing healthcheck should have failed")
+	}
+	if err = b.Update(&BackendInput{Healthcheck: String("")}); err != nil {
+		t.Errorf("Detaching the healthcheck failed: %s", err)
+	}
+
+	healthchecks, err := v.ListHealthchecks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(healthchecks) != 1 {
+		t.Errorf("Expected 1 healthcheck, got %d", len(healthchecks))
+	}
+	if err = h.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = v.GetHealthcheck("health"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a deleted healthcheck, got %v", err)
+	}
+}
+
+func TestDirector(t *testing.T) {
+	v, err := S.NewVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"east", "west"} {
+		if _, err := v.NewBackend(&BackendInput{Name: String(name), Address: String(name + ".fnerpherder.com")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err := v.NewDirector(&DirectorInput{Name: String("origins"), Type: Ptr(DirectorRoundRobin), Quorum: Int(50)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Type != DirectorRoundRobin || d.Quorum != 50 || d.Retries != 5 {
+		t.Errorf("Created director didn't match: %+v", d)
+	}
+	if d.Type.String() != "round-robin" {
+		t.Errorf("Expected the director type to be round-robin, got %s", d.Type)
+	}
+	if err = d.Update(&DirectorInput{Type: Ptr(DirectorHash), Retries: Int(2)}); err != nil {
+		t.Fatal(err)
+	}
+	if d.Type != DirectorHash || d.Retries != 2 || d.Quorum != 50 {
+		t.Errorf("Director didn't update as expected: %+v", d)
+	}
+
+	for _, name := range []string{"west", "east"} {
+		if err := d.AddBackend(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = d.AddBackend("nowhere"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error adding a missing backend, got %v", err)
+	}
+	d2, err := v.GetDirector("origins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backends, err := d2.Backends()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backends) != 2 || backends[0].Name != "west" || backends[1].Address != "east.fnerpherder.com" {
+		t.Errorf("The director's backends were wrong: %v", d2.BackendNames)
+	}
+	if err = d.RemoveBackend("west"); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.BackendNames) != 1 || d.BackendNames[0] != "east" {
+		t.Errorf("Expected only east left in the director, got %v", d.BackendNames)
+	}
+	d3, _ := v.GetDirector("origins")
+	if len(d3.BackendNames) != 1 {
+		t.Errorf("The backend wasn't removed at the source: %v", d3.BackendNames)
+	}
+
+	directors, err := v.ListDirectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(directors) != 1 {
+		t.Errorf("Expected 1 director, got %d", len(directors))
+	}
+	if err = d.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = v.GetDirector("origins"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a deleted director, got %v", err)
+	}
+}
+
+func TestCondition(t *testing.T) {
+	good := []string{
+		`req.url ~ "^/api/"`,
+		`req.http.Fastly-SSL && (client.geo.country_code == "US" || client.geo.country_code == "CA")`,
+		`!req.http.Cookie:session`,
+		`std.strlen(req.url) >= 100`,
+		`req.http.host != {"www.fnerpherder.com"}`,
+		`req.url !~ "\\.(jpg|png)$"`,
+		`req.http.host + req.url == "www.fnerpherder.com/"`,
+		`randombool(1, 10) && uuid.version4() != ""`,
+		`!(req.http.a || req.http.b)`,
+	}
+	for _, stmt := range good {
+		if err := CheckStatement(stmt); err != nil {
+			t.Errorf("Statement %s should have passed, got %s", stmt, err)
+		}
+	}
+	bad := map[string]int{
+		`(req.url ~ "^/api/"`:              0,
+		`req.url ~ "^/api/")`:              18,
+		`req.url ~ "^/api/`:                10,
+		`req.url = "/"`:                    8,
+		`req.url === "/"`:                  8,
+		`req.http.a & req.http.b`:          11,
+		`req.url == `:                      8,
+		`== "/"`:                           0,
+		`(req.url ~ "a" &&) || req.http.b`: 15,
+		`req.url ~ {"unterminated"`:        10,
+		``:                                 0,
+		`req.url ~ "a"; return(pass)`:      13,
+		`a == b c`:                         7,
+		`a == b "c"`:                       7,
+		`a == b (c)`:                       7,
+		`a ! b`:                            2,
+		`()`:                               1,
+		`a && ()`:                          6,
+		`a - b`:                            2,
+		`a += b`:                           2,
+		`f(a,)`:                            4,
+		`a, b`:                             1,
+	}
+	for stmt, pos := range bad {
+		err := CheckStatement(stmt)
+		var se *StatementError
+		if !errors.As(err, &se) {
+			t.Errorf("Statement %s should have failed with a StatementError, got %v", stmt, err)
+		} else if se.Pos != pos {
+			t.Errorf("Statement %s failed at %d, expected %d: %s", stmt, se.Pos, pos, se)
+		}
+	}
+
+	v, err := S.NewVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := v.NewCondition(&ConditionInput{Name: String("api"), Type: Ptr(ConditionRequest), Statement: String(`req.url ~ "^/api/"`), Priority: Int(10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Type != ConditionRequest || c.Priority != 10 || c.Statement != `req.url ~ "^/api/"` {
+		t.Errorf("Created condition didn't match: %+v", c)
+	}
+	if _, err = v.NewCondition(&ConditionInput{Name: String("broken"), Type: Ptr(ConditionCache), Statement: String(`(req.url`)}); err == nil {
+		t.Errorf("Creating a condition with a bad statement should have failed")
+	}
+	if _, err = v.NewCondition(&ConditionInput{Name: String("badtype"), Type: Ptr(ConditionType("PREFETCHING")), Statement: String(`req.url`)}); err == nil {
+		t.Errorf("Creating a condition with an unknown type should have failed")
+	}
+	if err = c.Update(&ConditionInput{Statement: String(`req.url ~ "^/v2/api/"`)}); err != nil {
+		t.Fatal(err)
+	}
+	if c.Statement != `req.url ~ "^/v2/api/"` || c.Priority != 10 {
+		t.Errorf("Condition didn't update as expected: %+v", c)
+	}
+	if err = c.Update(&ConditionInput{Statement: String(`req.url = "/"`)}); err == nil {
+		t.Errorf("Updating a condition with a bad statement should have failed")
+	}
+	c2, err := v.GetCondition("api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.Statement != c.Statement {
+		t.Errorf("Condition didn't update at the source: %+v", c2)
+	}
+	conditions, err := v.ListConditions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conditions) != 1 {
+		t.Errorf("Expected 1 condition, got %d", len(conditions))
+	}
+	if err = c.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = v.GetCondition("api"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a deleted condition, got %v", err)
+	}
+
+	// condition names are free text, and have to be escaped in paths
+	odd := "50% of /api?x #1"
+	c3, err := v.NewCondition(&ConditionInput{Name: String(odd), Type: Ptr(ConditionRequest), Statement: String(`randombool(50, 100)`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c4, err := v.GetCondition(odd); err != nil || c4.Name != odd {
+		t.Errorf("Getting a condition named %q failed: %v", odd, err)
+	}
+	if err = c3.Update(&ConditionInput{Priority: Int(20)}); err != nil || c3.Priority != 20 {
+		t.Errorf("Updating a condition named %q failed: %v", odd, err)
+	}
+	if err = c3.Delete(); err != nil {
+		t.Errorf("Deleting a condition named %q failed: %v", odd, err)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	v, err := S.NewVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = v.NewCondition(&ConditionInput{Name: String("is-api"), Type: Ptr(ConditionResponse), Statement: String(`req.url ~ "^/api/"`)}); err != nil {
+		t.Fatal(err)
+	}
+	h, err := v.NewHeader(&HeaderInput{Name: String("cors"), Action: Ptr(HeaderSet), Type: Ptr(HeaderResponse), Dst: String("http.Access-Control-Allow-Origin"), Src: String(`"*"`), ResponseCondition: String("is-api"), IgnoreIfSet: Bool(true)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Action != HeaderSet || h.Type != HeaderResponse || h.Src != `"*"` || h.ResponseCondition != "is-api" || !h.IgnoreIfSet || h.Priority != 100 {
+		t.Errorf("Created header didn't match: %+v", h)
+	}
+	if _, err = v.NewHeader(&HeaderInput{Name: String("bad"), Action: Ptr(HeaderAction("replace")), Type: Ptr(HeaderRequest), Dst: String("http.X-Foo")}); err == nil {
+		t.Errorf("Creating a header with an unknown action should have failed")
+	}
+	if _, err = v.NewHeader(&HeaderInput{Name: String("bad"), Action: Ptr(HeaderDelete), Type: Ptr(HeaderType("fetch")), Dst: String("http.X-Foo")}); err == nil {
+		t.Errorf("Creating a header with an unknown type should have failed")
+	}
+	err = h.Update(&HeaderInput{Action: Ptr(HeaderRegexRepeat), Src: String("http.Origin"), Regex: String("^http:"), Substitution: String("https:"), IgnoreIfSet: Bool(false), Priority: Int(5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Action != HeaderRegexRepeat || h.Regex != "^http:" || h.Substitution != "https:" || h.IgnoreIfSet || h.Priority != 5 {
+		t.Errorf("Header didn't update as expected: %+v", h)
+	}
+	if h.Dst != "http.Access-Control-Allow-Origin" || h.ResponseCondition != "is-api" {
+		t.Errorf("Updating some header fields changed others: %+v", h)
+	}
+	h2, err := v.GetHeader("cors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h2.Action != HeaderRegexRepeat || h2.Priority != 5 {
+		t.Errorf("Header didn't update at the source: %+v", h2)
+	}
+	if _, err = v.NewHeader(&HeaderInput{Name: String("no-cookies"), Action: Ptr(HeaderDelete), Type: Ptr(HeaderRequest), Dst: String("http.Cookie")}); err != nil {
+		t.Fatal(err)
+	}
+	headers, err := v.ListHeaders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 2 {
+		t.Errorf("Expected 2 headers, got %d", len(headers))
+	}
+	if err = h.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = v.GetHeader("cors"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a deleted header, got %v", err)
+	}
+}
+
+func TestListVersions(t *testing.T) {
+	all, err := S.ListVersions()
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if len(all) < 2 {
+		t.Fatalf("Expected at least 2 versions, got %d", len(all))
+	}
+	for i, v := range all {
+		if v.Number != int64(i+1) {
+			t.Errorf("Versions were out of order, expected %d, got %d", i+1, v.Number)
+		}
+	}
+	newer, err := S.ListVersions(NewerThan(1), OnlyUnlocked())
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if len(newer) != len(all)-1 {
+		t.Errorf("Expected %d versions newer than 1, got %d", len(all)-1, len(newer))
+	}
+	none, err := S.ListVersions(CommentContains("omg-totally-fake"))
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if len(none) != 0 {
+		t.Errorf("Expected no versions with that comment, got %d", len(none))
+	}
+	if len(S.versions) != len(all) {
+		t.Errorf("Expected %d cached versions, got %d", len(all), len(S.versions))
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	v, err := S.NewVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := v.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Valid() || val.Err() == nil {
+		t.Errorf("A version with no domains should not be valid: %+v", val)
+	}
+	_, err = v.NewDomain(&DomainInput{Name: String("validate.fnerpherder.com")})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	val, err = v.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = val.Err(); err != nil {
+		t.Errorf("Version should have been valid: %s", err)
+	}
+	if err = v.Update("known good"); err != nil {
+		t.Errorf(err.Error())
+	}
+	if v.Comment != "known good" {
+		t.Errorf("Version comment was not updated, got '%s'", v.Comment)
+	}
+	if err = v.Lock(); err != nil {
+		t.Errorf(err.Error())
+	}
+	if !v.Locked {
+		t.Errorf("Version was not locked")
+	}
+	_, err = v.NewDomain(&DomainInput{Name: String("locked.fnerpherder.com")})
+	if err == nil {
+		t.Errorf("Adding a domain to a locked version unexpectedly succeeded")
+	}
+}
+
+func TestValidationMessages(t *testing.T) {
+	body := `{"status":"ok","msg":null,"messages":[{"type":"info","text":"Using the default TTL"},{"type":"warning","text":"Unused condition"}]}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+	g := &Ghastly{Client: &Client{BaseUrl: ts.URL, Http: &http.Client{}}}
+	v := &Version{Number: 1, service: &Service{Id: "SU1Z0isxPaozGVKXdv0eY", ghastly: g}}
+	val, err := v.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = val.Err(); err != nil {
+		t.Errorf("Informational messages should not make a version invalid: %s", err)
+	}
+	if len(val.Infos) != 1 || len(val.Warnings) != 1 || len(val.Errors) != 0 {
+		t.Errorf("Messages were not sorted properly: %+v", val)
+	}
+	body = `{"status":"error","msg":null,"messages":[{"type":"error","text":"Unknown backend","line":12}]}`
+	if val, err = v.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if val.Valid() || len(val.Errors) != 1 {
+		t.Errorf("An error message should make a version invalid: %+v", val)
+	}
+}
+
+func TestActivateVersion(t *testing.T) {
+	v, err := S.NewVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = v.NewDomain(&DomainInput{Name: String("activate.fnerpherder.com")})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	err = v.ActivateAndWaitContext(ctx, 100*time.Millisecond)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if !v.Active || S.ActiveVersion != v.Number {
+		t.Errorf("Version %d was not marked active, service says %d is active", v.Number, S.ActiveVersion)
+	}
+	s2, err := G.GetService(S.Id)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if s2.ActiveVersion != v.Number {
+		t.Errorf("Expected version %d to be active at the source, got %d", v.Number, s2.ActiveVersion)
+	}
+}
+
+func TestDiffVersions(t *testing.T) {
+	from, err := S.NewVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	from.NewDomain(&DomainInput{Name: String("old.fnerpherder.com")})
+	from.NewDomain(&DomainInput{Name: String("same.fnerpherder.com")})
+	to, err := from.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := to.GetDomain("old.fnerpherder.com")
+	d.Delete()
+	d, _ = to.GetDomain("same.fnerpherder.com")
+	d.Update(&DomainInput{Comment: String("changed")})
+	to.NewDomain(&DomainInput{Name: String("new.fnerpherder.com")})
+
+	textDiff, err := S.Diff(from.Number, to.Number, DiffText)
+	if err != nil {
+		t.Errorf(err.Error())
+	} else if !strings.Contains(textDiff.Diff, "new.fnerpherder.com") {
+		t.Errorf("The text diff did not mention the new domain: %s", textDiff.Diff)
+	}
+	htmlDiff, err := S.Diff(from.Number, to.Number, DiffHTML)
+	if err != nil {
+		t.Errorf(err.Error())
+	} else if htmlDiff.Format != DiffHTML {
+		t.Errorf("Expected an html diff, got %s", htmlDiff.Format)
+	}
+
+	report, err := S.StructuralDiff(from.Number, to.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changes) != 3 {
+		t.Fatalf("Expected 3 changes, got %d:\n%s", len(report.Changes), report)
+	}
+	expected := []struct {
+		ct   ChangeType
+		name string
+	}{{Removed, "old.fnerpherder.com"}, {Added, "new.fnerpherder.com"}, {Modified, "same.fnerpherder.com"}}
+	for i, e := range expected {
+		c := report.Changes[i]
+		if c.Type != e.ct || c.Name != e.name || c.Kind != "domain" {
+			t.Errorf("Change %d was %s %s %s, expected %s domain %s", i, c.Type, c.Kind, c.Name, e.ct, e.name)
+		}
+	}
+	if f := report.Changes[2].Fields; len(f) != 1 || f[0].Field != "comment" || f[0].To != "changed" {
+		t.Errorf("The modified domain's fields were wrong: %+v", f)
+	}
+	if !strings.Contains(report.String(), "- Added domain `new.fnerpherder.com`") {
+		t.Errorf("The report was not formatted as expected:\n%s", report)
+	}
+
+	withBackend, err := to.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	withBackend.NewBackend(&BackendInput{Name: String("origin"), Address: String("192.0.2.1"), SslClientKey: String("old key")})
+	changedBackend, err := withBackend.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := changedBackend.GetBackend("origin")
+	b.Update(&BackendInput{Port: Int(8080), SslClientKey: String("new key")})
+	report, err = S.StructuralDiff(withBackend.Number, changedBackend.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changes) != 1 || report.Changes[0].Kind != "backend" || report.Changes[0].Type != Modified {
+		t.Fatalf("Expected one modified backend, got:\n%s", report)
+	}
+	f := report.Changes[0].Fields
+	if len(f) != 2 || f[0].Field != "port" || f[0].From != "80" || f[0].To != "8080" {
+		t.Fatalf("The modified backend's fields were wrong: %+v", f)
+	}
+	// a rotated key shows up, but not its value
+	if f[1].Field != "ssl_client_key" || f[1].From != "(set)" || f[1].To != "(changed)" {
+		t.Errorf("The client key change was wrong: %+v", f[1])
+	}
+	if strings.Contains(report.String(), "new key") {
+		t.Errorf("The report showed the client key:\n%s", report)
+	}
+}
+
+func TestDeploy(t *testing.T) {
+	previous := S.ActiveVersion
+	report, err := S.Deploy(func(v *Version) error {
+		_, err := v.NewDomain(&DomainInput{Name: String("deploy.fnerpherder.com")})
+		return err
+	}, &DeployOptions{Comment: "add deploy domain", PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Activated || report.PreviousVersion != previous || S.ActiveVersion != report.NewVersion {
+		t.Errorf("The deploy report was wrong: %+v", report)
+	}
+	if report.Finished.Before(report.Started) {
+		t.Errorf("The deploy finished before it started")
+	}
+
+	// a failed change should leave the active version alone
+	previous = S.ActiveVersion
+	report, err = S.Deploy(func(v *Version) error {
+		return fmt.Errorf("oops")
+	}, nil)
+	if err == nil {
+		t.Errorf("A deploy with a failing change unexpectedly succeeded")
+	}
+	if report.Activated || S.ActiveVersion != previous {
+		t.Errorf("The failed deploy activated version %d", S.ActiveVersion)
+	}
+	abandoned, _ := S.GetVersion(report.NewVersion)
+	if !strings.HasPrefix(abandoned.Comment, "Abandoned") {
+		t.Errorf("The failed deploy's version was not marked as abandoned: '%s'", abandoned.Comment)
+	}
+
+	// so should failing to set the new version's comment, and the clone
+	// is abandoned
+	g, err := New(testLoginOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var commented atomic.Bool
+	g.Use(func(next RoundTripFunc) RoundTripFunc {
+		return func(r *http.Request) (*http.Response, error) {
+			if r.Method == "PUT" && strings.Contains(r.URL.Path, "/version/") && !strings.HasSuffix(r.URL.Path, "/clone") && !commented.Swap(true) {
+				return nil, fmt.Errorf("comment failed")
+			}
+			return next(r)
+		}
+	})
+	s, err := g.GetService(S.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = s.Deploy(func(v *Version) error {
+		return nil
+	}, &DeployOptions{Comment: "never commented"})
+	if err == nil {
+		t.Errorf("A deploy that couldn't set its comment unexpectedly succeeded")
+	}
+	if report.NewVersion == 0 || report.Activated || s.ActiveVersion != previous {
+		t.Errorf("The deploy should have cloned a version and left %d active: %+v", previous, report)
+	}
+	abandoned, _ = S.GetVersion(report.NewVersion)
+	if !strings.HasPrefix(abandoned.Comment, "Abandoned") {
+		t.Errorf("The version whose comment failed was not marked as abandoned: '%s'", abandoned.Comment)
+	}
+
+	// failing health checks should roll back
+	report, err = S.Deploy(func(v *Version) error {
+		return v.Update("unhealthy")
+	}, &DeployOptions{PollInterval: 10 * time.Millisecond, HealthChecks: []func(context.Context, *Version) error{
+		func(ctx context.Context, v *Version) error {
+			return fmt.Errorf("origin is down")
+		},
+	}})
+	if err == nil {
+		t.Errorf("A deploy with a failing health check unexpectedly succeeded")
+	}
+	if !report.RolledBack || S.ActiveVersion != previous {
+		t.Errorf("The deploy was not rolled back, version %d is active", S.ActiveVersion)
+	}
+
+	// if waiting for the activation fails, the new version is still live
+	// and has to be rolled back
+	g, err = New(testLoginOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var activated atomic.Bool
+	g.Use(func(next RoundTripFunc) RoundTripFunc {
+		return func(r *http.Request) (*http.Response, error) {
+			if r.Method == "PUT" && strings.HasSuffix(r.URL.Path, "/activate") {
+				activated.Store(true)
+			} else if r.Method == "GET" && r.URL.Path == "/service/"+S.Id && activated.Load() {
+				return nil, fmt.Errorf("polling failed")
+			}
+			return next(r)
+		}
+	})
+	s, err = g.GetService(S.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = s.Deploy(func(v *Version) error {
+		return v.Update("never seen active")
+	}, &DeployOptions{PollInterval: 10 * time.Millisecond})
+	if err == nil {
+		t.Errorf("A deploy that couldn't confirm its activation unexpectedly succeeded")
+	}
+	if !report.Activated || !report.RolledBack || s.ActiveVersion != previous {
+		t.Errorf("The deploy should have been activated and rolled back to %d: %+v, %d is active", previous, report, s.ActiveVersion)
+	}
+	S.setActiveVersion(s.ActiveVersion)
+}
+
+func TestRollback(t *testing.T) {
+	history, err := S.ActivationHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) < 2 {
+		t.Fatalf("Expected at least 2 activations, got %d", len(history))
+	}
+	if history[0].Version != S.ActiveVersion {
+		t.Errorf("The latest activation was version %d, but %d is active", history[0].Version, S.ActiveVersion)
+	}
+	for i := 1; i < len(history); i++ {
+		if history[i].ActivatedAt.After(history[i-1].ActivatedAt) {
+			t.Errorf("Activation history is out of order at %d", i)
+		}
+	}
+	bad, err := S.NewVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad.NewDomain(&DomainInput{Name: String("rollback.fnerpherder.com")})
+	good := S.ActiveVersion
+	if err = bad.Activate(); err != nil {
+		t.Fatal(err)
+	}
+	v, err := S.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Number != good || S.ActiveVersion != good {
+		t.Errorf("Rolled back to version %d, expected %d", v.Number, good)
+	}
+
+	// rolling back after a deploy rolled itself back shouldn't bring back
+	// the failed version
+	report, err := S.Deploy(func(v *Version) error {
+		return v.Update("rolled back")
+	}, &DeployOptions{PollInterval: 10 * time.Millisecond, HealthChecks: []func(context.Context, *Version) error{
+		func(ctx context.Context, v *Version) error {
+			return fmt.Errorf("origin is down")
+		},
+	}})
+	if err == nil || !report.RolledBack {
+		t.Fatalf("The deploy should have rolled back: %v", err)
+	}
+	v, err = S.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Number == report.NewVersion || v.Number == good || v.Number == bad.Number {
+		t.Errorf("Rolled back to version %d, which was rolled back or already active", v.Number)
+	}
+
+	// rolling back twice in a row goes back two activations, rather than
+	// back and forth between the same two versions
+	start := S.ActiveVersion
+	var activated []int64
+	for i := 0; i < 2; i++ {
+		nv, err := S.NewVersion()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = nv.Activate(); err != nil {
+			t.Fatal(err)
+		}
+		activated = append(activated, nv.Number)
+	}
+	for _, want := range []int64{activated[0], start} {
+		v, err = S.Rollback()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Number != want || S.ActiveVersion != want {
+			t.Errorf("Rolled back to version %d, expected %d", v.Number, want)
+		}
+	}
+}
+
+func TestPurge(t *testing.T) {
+	pid, err := G.PurgeURL("http://localhost/img.png")
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if pid == "" {
+		t.Errorf("Purge id after purging was unexpectedly nil")
+	}
+
+	err = S.PurgeAll()
+	if err != nil {
+		t.Errorf(err.Error())
+	}
 	err = S.PurgeKey("uhok")
 	if err != nil {
 		t.Errorf(err.Error())
@@ -0,0 +1,94 @@
+package ghastlytest
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A line for each object attached to the version, in a stable order. Diffs
+// are made by comparing these.
+func (v *version) dump() []string {
+	var lines []string
+	for kind, objs := range v.objects {
+		for _, o := range objs {
+			fields := make([]string, 0, len(o))
+			for k, val := range o {
+				if k == "name" || k == "version" || k == "service_id" {
+					continue
+				}
+				fields = append(fields, fmt.Sprintf("%s=%v", k, val))
+			}
+			sort.Strings(fields)
+			lines = append(lines, fmt.Sprintf("%s %s: %s", kind, o["name"], strings.Join(fields, " ")))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// A simple line based diff: lines only in the old version are prefixed with
+// "-", and lines only in the new one with "+".
+func diffLines(from, to []string) []string {
+	inFrom := make(map[string]bool, len(from))
+	inTo := make(map[string]bool, len(to))
+	for _, l := range from {
+		inFrom[l] = true
+	}
+	for _, l := range to {
+		inTo[l] = true
+	}
+	var diff []string
+	for _, l := range from {
+		if !inTo[l] {
+			diff = append(diff, "- "+l)
+		}
+	}
+	for _, l := range to {
+		if !inFrom[l] {
+			diff = append(diff, "+ "+l)
+		}
+	}
+	return diff
+}
+
+func (s *Server) diffVersions(w http.ResponseWriter, r *http.Request, svc *service) {
+	var nums [2]int
+	for i, p := range []string{"from", "to"} {
+		n, err := strconv.Atoi(r.PathValue(p))
+		if err != nil || n < 1 || n > len(svc.versions) {
+			notFound(w, r, "Couldn't find Version '%s' for service '%s'", r.PathValue(p), svc.id)
+			return
+		}
+		nums[i] = n
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "text"
+	}
+	lines := diffLines(svc.versions[nums[0]-1].dump(), svc.versions[nums[1]-1].dump())
+	var diff string
+	switch format {
+	case "text":
+		diff = strings.Join(lines, "\n")
+	case "html", "html_simple":
+		var b strings.Builder
+		b.WriteString("<pre>")
+		for _, l := range lines {
+			class := "added"
+			if strings.HasPrefix(l, "-") {
+				class = "removed"
+			}
+			fmt.Fprintf(&b, "<span class=\"%s\">%s</span>\n", class, html.EscapeString(l))
+		}
+		b.WriteString("</pre>")
+		diff = b.String()
+	default:
+		badRequest(w, r, "Unknown format '%s'", format)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, object{"from": nums[0], "to": nums[1], "format": format, "diff": diff})
+}
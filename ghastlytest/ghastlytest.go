@@ -0,0 +1,556 @@
+// Package ghastlytest provides an in-process fake of the Fastly API, for
+// testing code that uses ghastly without a Fastly account. It keeps everything
+// in memory and understands enough of the API for ghastly's own tests: logging
+// in, services, versions, the objects that hang off of versions, and purging.
+//
+//	fake := ghastlytest.NewServer()
+//	defer fake.Close()
+//	g, err := ghastly.New(fake.LoginOpts())
+package ghastlytest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The credentials the fake accepts, unless changed on the Server.
+const (
+	DefaultUser     = "test@example.com"
+	DefaultPassword = "password"
+	DefaultApiKey   = "fake-api-key"
+	CustomerId      = "fakecustomer0000000001"
+)
+
+const sessionCookie = "fastly.session"
+
+// A Server is a fake Fastly API running on a local httptest server.
+type Server struct {
+	*httptest.Server
+	// Credentials that are accepted by /login and in the Fastly-Key
+	// header. They may be changed before making any requests.
+	User     string
+	Password string
+	ApiKey   string
+
+	mu       sync.Mutex
+	sessions map[string]bool
+	services map[string]*service
+	purges   []string
+	events   []event
+}
+
+// Something that happened to a service, for the event log.
+type event struct {
+	id        string
+	eventType string
+	serviceId string
+	version   int
+	createdAt time.Time
+}
+
+type service struct {
+	id        string
+	name      string
+	comment   string
+	createdAt time.Time
+	updatedAt time.Time
+	versions  []*version
+}
+
+type version struct {
+	number    int
+	comment   string
+	active    bool
+	locked    bool
+	deployed  bool
+	staging   bool
+	testing   bool
+	createdAt time.Time
+	updatedAt time.Time
+	// objects belonging to the version, like domains, by kind and then
+	// in the order they were created.
+	objects map[string][]object
+}
+
+// An object attached to a version, like a domain or a backend, kept as the
+// JSON object the API returns.
+type object map[string]interface{}
+
+// Start a new fake Fastly API server. Close it when done.
+func NewServer() *Server {
+	s := &Server{User: DefaultUser, Password: DefaultPassword, ApiKey: DefaultApiKey}
+	s.sessions = make(map[string]bool)
+	s.services = make(map[string]*service)
+	s.Server = httptest.NewServer(s.routes())
+	return s
+}
+
+// Options for ghastly.New that log in to the fake and send purges to it.
+func (s *Server) LoginOpts() map[string]string {
+	return map[string]string{"user": s.User, "password": s.Password, "base_url": s.URL, "purge_base_url": s.URL}
+}
+
+// Expire all logged in sessions, as if they had timed out.
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = make(map[string]bool)
+}
+
+// The URLs that have been purged with PURGE requests, in order.
+func (s *Server) Purges() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.purges...)
+}
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", s.login)
+	mux.HandleFunc("PURGE /", s.purgeURL)
+
+	mux.HandleFunc("GET /events", s.auth(s.listEvents))
+	mux.HandleFunc("GET /service", s.auth(s.listServices))
+	mux.HandleFunc("POST /service", s.auth(s.createService))
+	mux.HandleFunc("GET /service/search", s.auth(s.searchServices))
+	mux.HandleFunc("GET /service/{id}", s.auth(s.withService(s.getService)))
+	mux.HandleFunc("PUT /service/{id}", s.auth(s.withService(s.updateService)))
+	mux.HandleFunc("DELETE /service/{id}", s.auth(s.deleteService))
+	mux.HandleFunc("GET /service/{id}/details", s.auth(s.withService(s.serviceDetails)))
+	mux.HandleFunc("POST /service/{id}/purge_all", s.auth(s.withService(s.purgeService)))
+	mux.HandleFunc("POST /service/{id}/purge/{key}", s.auth(s.withService(s.purgeService)))
+
+	mux.HandleFunc("GET /service/{id}/diff/from/{from}/to/{to}", s.auth(s.withService(s.diffVersions)))
+	mux.HandleFunc("GET /service/{id}/version", s.auth(s.withService(s.listVersions)))
+	mux.HandleFunc("POST /service/{id}/version", s.auth(s.withService(s.createVersion)))
+	mux.HandleFunc("GET /service/{id}/version/{n}", s.auth(s.withVersion(s.getVersion)))
+	mux.HandleFunc("PUT /service/{id}/version/{n}", s.auth(s.withVersion(s.updateVersion)))
+	mux.HandleFunc("GET /service/{id}/version/{n}/validate", s.auth(s.withVersion(s.validateVersion)))
+	mux.HandleFunc("PUT /service/{id}/version/{n}/clone", s.auth(s.withVersion(s.cloneVersion)))
+	mux.HandleFunc("PUT /service/{id}/version/{n}/activate", s.auth(s.withVersion(s.activateVersion)))
+	mux.HandleFunc("PUT /service/{id}/version/{n}/deactivate", s.auth(s.withVersion(s.deactivateVersion)))
+	mux.HandleFunc("PUT /service/{id}/version/{n}/lock", s.auth(s.withVersion(s.lockVersion)))
+
+	mux.HandleFunc("GET /service/{id}/version/{n}/domain/check_all", s.auth(s.withVersion(s.checkAllDomains)))
+	mux.HandleFunc("GET /service/{id}/version/{n}/domain/{name}/check", s.auth(s.withVersion(s.checkDomain)))
+
+	mux.HandleFunc("GET /service/{id}/version/{n}/director/{name}/backend/{backend}", s.auth(s.withVersion(s.getDirectorBackend)))
+	mux.HandleFunc("POST /service/{id}/version/{n}/director/{name}/backend/{backend}", s.auth(s.withVersion(s.addDirectorBackend)))
+	mux.HandleFunc("DELETE /service/{id}/version/{n}/director/{name}/backend/{backend}", s.auth(s.withVersion(s.removeDirectorBackend)))
+
+	mux.HandleFunc("GET /service/{id}/version/{n}/{kind}", s.auth(s.withVersion(s.listObjects)))
+	mux.HandleFunc("POST /service/{id}/version/{n}/{kind}", s.auth(s.withVersion(s.createObject)))
+	mux.HandleFunc("GET /service/{id}/version/{n}/{kind}/{name}", s.auth(s.withVersion(s.getObject)))
+	mux.HandleFunc("PUT /service/{id}/version/{n}/{kind}/{name}", s.auth(s.withVersion(s.updateObject)))
+	mux.HandleFunc("DELETE /service/{id}/version/{n}/{kind}/{name}", s.auth(s.withVersion(s.deleteObject)))
+	return mux
+}
+
+// Reply with JSON, adding the rate limit headers Fastly sends on calls that
+// change things.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Fastly-Request-Id", newId(8))
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Fastly-RateLimit-Remaining", "999")
+		w.Header().Set("Fastly-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Truncate(time.Hour).Unix(), 10))
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Reply with an error in the same shape as the API's errors.
+func writeError(w http.ResponseWriter, r *http.Request, status int, msg, detail string) {
+	body := map[string]string{"msg": msg}
+	if detail != "" {
+		body["detail"] = detail
+	}
+	writeJSON(w, r, status, body)
+}
+
+func notFound(w http.ResponseWriter, r *http.Request, format string, v ...interface{}) {
+	writeError(w, r, http.StatusNotFound, "Record not found", fmt.Sprintf(format, v...))
+}
+
+func badRequest(w http.ResponseWriter, r *http.Request, format string, v ...interface{}) {
+	writeError(w, r, http.StatusBadRequest, "Bad request", fmt.Sprintf(format, v...))
+}
+
+func statusOK(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func newId(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("user") != s.User || r.FormValue("password") != s.Password || s.User == "" {
+		writeError(w, r, http.StatusUnauthorized, "Provided credentials are missing or invalid", "")
+		return
+	}
+	token := newId(16)
+	s.mu.Lock()
+	s.sessions[token] = true
+	s.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: token, Path: "/"})
+	writeJSON(w, r, http.StatusOK, map[string]string{"login": s.User, "customer_id": CustomerId})
+}
+
+func (s *Server) auth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		ok := s.ApiKey != "" && r.Header.Get("Fastly-Key") == s.ApiKey
+		if c, err := r.Cookie(sessionCookie); err == nil && s.sessions[c.Value] {
+			ok = true
+		}
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, r, http.StatusUnauthorized, "Provided credentials are missing or invalid", "")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// Look up the service in the request path and call h with the lock held.
+func (s *Server) withService(h func(http.ResponseWriter, *http.Request, *service)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		svc, ok := s.services[r.PathValue("id")]
+		if !ok {
+			notFound(w, r, "Couldn't find Service '%s'", r.PathValue("id"))
+			return
+		}
+		h(w, r, svc)
+	}
+}
+
+// Look up the service and version in the request path and call h with the
+// lock held.
+func (s *Server) withVersion(h func(http.ResponseWriter, *http.Request, *service, *version)) http.HandlerFunc {
+	return s.withService(func(w http.ResponseWriter, r *http.Request, svc *service) {
+		n, err := strconv.Atoi(r.PathValue("n"))
+		if err != nil || n < 1 || n > len(svc.versions) {
+			notFound(w, r, "Couldn't find Version '%s' for service '%s'", r.PathValue("n"), svc.id)
+			return
+		}
+		h(w, r, svc, svc.versions[n-1])
+	})
+}
+
+func (s *Server) purgeURL(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.purges = append(s.purges, r.URL.String())
+	s.mu.Unlock()
+	writeJSON(w, r, http.StatusOK, map[string]string{"status": "ok", "id": newId(8)})
+}
+
+func (s *Server) purgeService(w http.ResponseWriter, r *http.Request, svc *service) {
+	statusOK(w, r)
+}
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (svc *service) activeVersion() int {
+	for _, v := range svc.versions {
+		if v.active {
+			return v.number
+		}
+	}
+	return 0
+}
+
+// The service as it appears on its own, in searches, and in the list of
+// services.
+func (svc *service) json() object {
+	versions := make([]object, len(svc.versions))
+	for i, v := range svc.versions {
+		versions[i] = v.json(svc)
+	}
+	return object{"id": svc.id, "name": svc.name, "comment": svc.comment, "customer_id": CustomerId, "version": svc.activeVersion(), "versions": versions, "created_at": timestamp(svc.createdAt), "updated_at": timestamp(svc.updatedAt)}
+}
+
+// The service's details, where "version" is the latest version instead of
+// the active version number, and both that and the active version come with
+// everything attached to them.
+func (svc *service) details() object {
+	o := svc.json()
+	o["version"] = svc.versions[len(svc.versions)-1].expanded(svc)
+	o["active_version"] = nil
+	if n := svc.activeVersion(); n != 0 {
+		o["active_version"] = svc.versions[n-1].expanded(svc)
+	}
+	return o
+}
+
+func (v *version) json(svc *service) object {
+	return object{"number": v.number, "service_id": svc.id, "comment": v.comment, "active": v.active, "locked": v.locked, "deployed": v.deployed, "staging": v.staging, "testing": v.testing, "created_at": timestamp(v.createdAt), "updated_at": timestamp(v.updatedAt), "deleted_at": nil}
+}
+
+// The version with lists of each kind of object attached to it, under the
+// plural of the kind's name.
+func (v *version) expanded(svc *service) object {
+	o := v.json(svc)
+	for kind := range kinds {
+		list := v.objects[kind]
+		if list == nil {
+			list = []object{}
+		}
+		o[kind+"s"] = list
+	}
+	return o
+}
+
+func (svc *service) newVersion() *version {
+	now := time.Now()
+	v := &version{number: len(svc.versions) + 1, createdAt: now, updatedAt: now, objects: make(map[string][]object)}
+	svc.versions = append(svc.versions, v)
+	return v
+}
+
+func (s *Server) sortedServices() []*service {
+	svcs := make([]*service, 0, len(s.services))
+	for _, svc := range s.services {
+		svcs = append(svcs, svc)
+	}
+	sort.Slice(svcs, func(i, j int) bool {
+		if svcs[i].createdAt.Equal(svcs[j].createdAt) {
+			return svcs[i].id < svcs[j].id
+		}
+		return svcs[i].createdAt.Before(svcs[j].createdAt)
+	})
+	return svcs
+}
+
+// Services are listed oldest first, a page at a time if "page" and "per_page"
+// are given.
+func (s *Server) listServices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	svcs := s.sortedServices()
+	q := r.URL.Query()
+	if q.Get("page") != "" || q.Get("per_page") != "" {
+		page, err := strconv.Atoi(q.Get("page"))
+		if q.Get("page") == "" {
+			page, err = 1, nil
+		}
+		perPage, perr := strconv.Atoi(q.Get("per_page"))
+		if q.Get("per_page") == "" {
+			perPage, perr = 20, nil
+		}
+		if err != nil || perr != nil || page < 1 || perPage < 1 {
+			badRequest(w, r, "Invalid page or per_page")
+			return
+		}
+		start := (page - 1) * perPage
+		if start > len(svcs) {
+			start = len(svcs)
+		}
+		end := start + perPage
+		if end > len(svcs) {
+			end = len(svcs)
+		}
+		svcs = svcs[start:end]
+	}
+	list := make([]object, 0, len(svcs))
+	for _, svc := range svcs {
+		list = append(list, svc.json())
+	}
+	writeJSON(w, r, http.StatusOK, list)
+}
+
+func (s *Server) createService(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	if name == "" {
+		badRequest(w, r, "Name can't be blank")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, svc := range s.services {
+		if svc.name == name {
+			writeError(w, r, http.StatusConflict, "Duplicate record", fmt.Sprintf("Service with name '%s' already exists", name))
+			return
+		}
+	}
+	now := time.Now()
+	svc := &service{id: newId(11), name: name, comment: r.FormValue("comment"), createdAt: now, updatedAt: now}
+	// new services come with an empty first version
+	svc.newVersion()
+	s.services[svc.id] = svc
+	writeJSON(w, r, http.StatusOK, svc.json())
+}
+
+func (s *Server) searchServices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := r.URL.Query().Get("name")
+	for _, svc := range s.services {
+		if svc.name == name {
+			writeJSON(w, r, http.StatusOK, svc.json())
+			return
+		}
+	}
+	notFound(w, r, "Couldn't find Service with name '%s'", name)
+}
+
+func (s *Server) getService(w http.ResponseWriter, r *http.Request, svc *service) {
+	writeJSON(w, r, http.StatusOK, svc.json())
+}
+
+func (s *Server) serviceDetails(w http.ResponseWriter, r *http.Request, svc *service) {
+	writeJSON(w, r, http.StatusOK, svc.details())
+}
+
+func (s *Server) updateService(w http.ResponseWriter, r *http.Request, svc *service) {
+	r.ParseForm()
+	if name, ok := r.Form["name"]; ok {
+		if name[0] == "" {
+			badRequest(w, r, "Name can't be blank")
+			return
+		}
+		svc.name = name[0]
+	}
+	if comment, ok := r.Form["comment"]; ok {
+		svc.comment = comment[0]
+	}
+	svc.updatedAt = time.Now()
+	writeJSON(w, r, http.StatusOK, svc.json())
+}
+
+func (s *Server) deleteService(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := r.PathValue("id")
+	if _, ok := s.services[id]; !ok {
+		notFound(w, r, "Couldn't find Service '%s'", id)
+		return
+	}
+	delete(s.services, id)
+	statusOK(w, r)
+}
+
+func (s *Server) listVersions(w http.ResponseWriter, r *http.Request, svc *service) {
+	list := make([]object, len(svc.versions))
+	for i, v := range svc.versions {
+		list[i] = v.json(svc)
+	}
+	writeJSON(w, r, http.StatusOK, list)
+}
+
+func (s *Server) createVersion(w http.ResponseWriter, r *http.Request, svc *service) {
+	v := svc.newVersion()
+	writeJSON(w, r, http.StatusOK, v.json(svc))
+}
+
+func (s *Server) getVersion(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	writeJSON(w, r, http.StatusOK, v.json(svc))
+}
+
+func (s *Server) updateVersion(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	r.ParseForm()
+	if comment, ok := r.PostForm["comment"]; ok {
+		v.comment = comment[0]
+	}
+	v.updatedAt = time.Now()
+	writeJSON(w, r, http.StatusOK, v.json(svc))
+}
+
+// Versions without any domains are invalid, and versions without backends
+// get a warning.
+func (s *Server) validateVersion(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	status := "ok"
+	errs := []string{}
+	warnings := []string{}
+	messages := []object{}
+	if len(v.objects["domain"]) == 0 {
+		status = "error"
+		errs = append(errs, "No domains defined")
+		messages = append(messages, object{"type": "error", "msg": "No domains defined", "detail": fmt.Sprintf("Version %d has no domains", v.number)})
+	}
+	if len(v.objects["backend"]) == 0 {
+		warnings = append(warnings, "No backends defined")
+		messages = append(messages, object{"type": "warning", "msg": "No backends defined", "detail": "Requests will not be able to reach an origin"})
+	}
+	writeJSON(w, r, http.StatusOK, object{"status": status, "msg": nil, "errors": errs, "warnings": warnings, "messages": messages})
+}
+
+func (s *Server) cloneVersion(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	nv := svc.newVersion()
+	nv.comment = v.comment
+	for kind, objs := range v.objects {
+		for _, o := range objs {
+			no := make(object, len(o))
+			for k, val := range o {
+				no[k] = val
+			}
+			no["version"] = nv.number
+			nv.objects[kind] = append(nv.objects[kind], no)
+		}
+	}
+	writeJSON(w, r, http.StatusOK, nv.json(svc))
+}
+
+func (s *Server) activateVersion(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	for _, ov := range svc.versions {
+		ov.active = false
+	}
+	v.active = true
+	v.locked = true
+	v.deployed = true
+	v.updatedAt = time.Now()
+	svc.updatedAt = v.updatedAt
+	s.events = append(s.events, event{id: newId(11), eventType: "version.activate", serviceId: svc.id, version: v.number, createdAt: v.updatedAt})
+	writeJSON(w, r, http.StatusOK, v.json(svc))
+}
+
+func (s *Server) deactivateVersion(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	if !v.active {
+		badRequest(w, r, "Version %d is not active", v.number)
+		return
+	}
+	v.active = false
+	v.updatedAt = time.Now()
+	s.events = append(s.events, event{id: newId(11), eventType: "version.deactivate", serviceId: svc.id, version: v.number, createdAt: v.updatedAt})
+	writeJSON(w, r, http.StatusOK, v.json(svc))
+}
+
+func (s *Server) lockVersion(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	v.locked = true
+	v.updatedAt = time.Now()
+	writeJSON(w, r, http.StatusOK, v.json(svc))
+}
+
+// The event log, newest first, filtered by service and event type.
+func (s *Server) listEvents(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	data := []object{}
+	for i := len(s.events) - 1; i >= 0; i-- {
+		e := s.events[i]
+		if sid := q.Get("filter[service_id]"); sid != "" && sid != e.serviceId {
+			continue
+		}
+		if et := q.Get("filter[event_type]"); et != "" && et != e.eventType {
+			continue
+		}
+		attrs := object{"created_at": timestamp(e.createdAt), "customer_id": CustomerId, "description": fmt.Sprintf("Version %d was %sd", e.version, e.eventType[len("version."):]), "event_type": e.eventType, "service_id": e.serviceId, "user_id": "fakeuser", "metadata": object{"version": e.version}}
+		data = append(data, object{"id": e.id, "type": "event", "attributes": attrs})
+	}
+	writeJSON(w, r, http.StatusOK, object{"data": data})
+}
@@ -0,0 +1,313 @@
+package ghastlytest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// How to store the form fields of each kind of version object, and what new
+// objects start out with. Fields not listed are kept as strings.
+type kindSpec struct {
+	ints     []string
+	bools    []string
+	required []string
+	defaults object
+}
+
+var kinds = map[string]kindSpec{
+	"domain": {},
+	"backend": {
+		ints:     []string{"port", "connect_timeout", "first_byte_timeout", "between_bytes_timeout", "weight"},
+		bools:    []string{"use_ssl", "ssl_check_cert", "auto_loadbalance"},
+		required: []string{"address"},
+		defaults: object{"port": 80, "connect_timeout": 1000, "first_byte_timeout": 15000, "between_bytes_timeout": 10000, "weight": 100, "use_ssl": false, "ssl_check_cert": true, "auto_loadbalance": false, "shield": nil, "healthcheck": nil, "request_condition": nil},
+	},
+	"healthcheck": {
+		ints:     []string{"expected_response", "check_interval", "timeout", "threshold", "window", "initial"},
+		defaults: object{"path": "/", "host": nil, "method": "HEAD", "expected_response": 200, "http_version": "1.1", "check_interval": 5000, "timeout": 500, "threshold": 3, "window": 5, "initial": 2},
+	},
+	"director": {
+		ints:     []string{"type", "quorum", "retries", "capacity"},
+		defaults: object{"type": 1, "quorum": 75, "retries": 5, "capacity": 100, "shield": nil, "backends": []string{}},
+	},
+	// Fastly sends condition priorities as strings, so they're left alone.
+	"condition": {
+		required: []string{"type", "statement"},
+		defaults: object{"priority": "100"},
+	},
+	// Likewise header priorities, and ignore_if_set is "0" or "1".
+	"header": {
+		required: []string{"action", "type", "dst"},
+		defaults: object{"priority": "100", "ignore_if_set": "0", "src": nil, "regex": nil, "substitution": nil, "request_condition": nil, "cache_condition": nil, "response_condition": nil},
+	},
+}
+
+func (k kindSpec) convert(field, value string) (interface{}, error) {
+	for _, f := range k.ints {
+		if f == field {
+			if value == "" {
+				return nil, nil
+			}
+			return strconv.Atoi(value)
+		}
+	}
+	for _, f := range k.bools {
+		if f == field {
+			switch value {
+			case "1", "true":
+				return true, nil
+			case "0", "false", "":
+				return false, nil
+			}
+			return nil, fmt.Errorf("'%s' is not a valid boolean", value)
+		}
+	}
+	return value, nil
+}
+
+// Look up the kind of object in the request path, replying with an error if
+// it isn't one the fake knows about.
+func lookupKind(w http.ResponseWriter, r *http.Request) (string, kindSpec, bool) {
+	kind := r.PathValue("kind")
+	spec, ok := kinds[kind]
+	if !ok {
+		notFound(w, r, "Unknown path '%s'", r.URL.Path)
+	}
+	return kind, spec, ok
+}
+
+func (v *version) find(kind, name string) (int, object) {
+	for i, o := range v.objects[kind] {
+		if o["name"] == name {
+			return i, o
+		}
+	}
+	return -1, nil
+}
+
+// Check that the version can be changed.
+func checkUnlocked(w http.ResponseWriter, r *http.Request, v *version) bool {
+	if v.locked {
+		badRequest(w, r, "Version %d is locked", v.number)
+		return false
+	}
+	return true
+}
+
+// Set fields on the object from the request's form, checking the types.
+func setFields(w http.ResponseWriter, r *http.Request, spec kindSpec, o object) bool {
+	r.ParseForm()
+	for k, vals := range r.PostForm {
+		val, err := spec.convert(k, vals[0])
+		if err != nil {
+			badRequest(w, r, "Invalid value for %s: %s", k, err)
+			return false
+		}
+		o[k] = val
+	}
+	return true
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	kind, _, ok := lookupKind(w, r)
+	if !ok {
+		return
+	}
+	list := v.objects[kind]
+	if list == nil {
+		list = []object{}
+	}
+	writeJSON(w, r, http.StatusOK, list)
+}
+
+func (s *Server) createObject(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	kind, spec, ok := lookupKind(w, r)
+	if !ok || !checkUnlocked(w, r, v) {
+		return
+	}
+	name := r.FormValue("name")
+	if name == "" {
+		badRequest(w, r, "Name can't be blank")
+		return
+	}
+	if _, o := v.find(kind, name); o != nil {
+		writeError(w, r, http.StatusConflict, "Duplicate record", fmt.Sprintf("Duplicate %s: '%s'", kind, name))
+		return
+	}
+	for _, f := range spec.required {
+		if r.FormValue(f) == "" {
+			badRequest(w, r, "%s can't be blank", f)
+			return
+		}
+	}
+	o := object{"service_id": svc.id, "version": v.number, "comment": ""}
+	for k, val := range spec.defaults {
+		o[k] = val
+	}
+	if !setFields(w, r, spec, o) {
+		return
+	}
+	v.objects[kind] = append(v.objects[kind], o)
+	v.updatedAt = time.Now()
+	writeJSON(w, r, http.StatusOK, o)
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	kind, _, ok := lookupKind(w, r)
+	if !ok {
+		return
+	}
+	_, o := v.find(kind, r.PathValue("name"))
+	if o == nil {
+		notFound(w, r, "Couldn't find %s '%s'", kind, r.PathValue("name"))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, o)
+}
+
+func (s *Server) updateObject(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	kind, spec, ok := lookupKind(w, r)
+	if !ok || !checkUnlocked(w, r, v) {
+		return
+	}
+	_, o := v.find(kind, r.PathValue("name"))
+	if o == nil {
+		notFound(w, r, "Couldn't find %s '%s'", kind, r.PathValue("name"))
+		return
+	}
+	r.ParseForm()
+	if name := r.PostForm.Get("name"); name != "" && name != o["name"] {
+		if _, dup := v.find(kind, name); dup != nil {
+			writeError(w, r, http.StatusConflict, "Duplicate record", fmt.Sprintf("Duplicate %s: '%s'", kind, name))
+			return
+		}
+	}
+	if _, ok := r.PostForm["name"]; ok && r.PostForm.Get("name") == "" {
+		badRequest(w, r, "Name can't be blank")
+		return
+	}
+	if !setFields(w, r, spec, o) {
+		return
+	}
+	v.updatedAt = time.Now()
+	writeJSON(w, r, http.StatusOK, o)
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	kind, _, ok := lookupKind(w, r)
+	if !ok || !checkUnlocked(w, r, v) {
+		return
+	}
+	i, o := v.find(kind, r.PathValue("name"))
+	if o == nil {
+		notFound(w, r, "Couldn't find %s '%s'", kind, r.PathValue("name"))
+		return
+	}
+	v.objects[kind] = append(v.objects[kind][:i], v.objects[kind][i+1:]...)
+	v.updatedAt = time.Now()
+	statusOK(w, r)
+}
+
+// Domain checks are the domain, the CNAME it points at, and whether that's
+// right. None of the fake's domains really exist, so they're never right.
+func domainCheck(o object) []interface{} {
+	return []interface{}{o, nil, false}
+}
+
+func (s *Server) checkDomain(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	_, o := v.find("domain", r.PathValue("name"))
+	if o == nil {
+		notFound(w, r, "Couldn't find domain '%s'", r.PathValue("name"))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, domainCheck(o))
+}
+
+func (s *Server) checkAllDomains(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	checks := make([]interface{}, 0, len(v.objects["domain"]))
+	for _, o := range v.objects["domain"] {
+		checks = append(checks, domainCheck(o))
+	}
+	writeJSON(w, r, http.StatusOK, checks)
+}
+
+// Find the director and backend named in the request path, replying with an
+// error if either is missing.
+func findDirectorBackend(w http.ResponseWriter, r *http.Request, v *version) (object, string, bool) {
+	_, d := v.find("director", r.PathValue("name"))
+	if d == nil {
+		notFound(w, r, "Couldn't find director '%s'", r.PathValue("name"))
+		return nil, "", false
+	}
+	backend := r.PathValue("backend")
+	if _, b := v.find("backend", backend); b == nil {
+		notFound(w, r, "Couldn't find backend '%s'", backend)
+		return nil, "", false
+	}
+	return d, backend, true
+}
+
+func directorBackendJSON(svc *service, v *version, director, backend string) object {
+	return object{"service_id": svc.id, "version": v.number, "director": director, "backend": backend}
+}
+
+func (s *Server) getDirectorBackend(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	d, backend, ok := findDirectorBackend(w, r, v)
+	if !ok {
+		return
+	}
+	for _, b := range d["backends"].([]string) {
+		if b == backend {
+			writeJSON(w, r, http.StatusOK, directorBackendJSON(svc, v, d["name"].(string), backend))
+			return
+		}
+	}
+	notFound(w, r, "Backend '%s' is not in director '%s'", backend, d["name"])
+}
+
+// The director's list of backends is replaced rather than changed in place,
+// since cloned versions share it.
+func (s *Server) addDirectorBackend(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	if !checkUnlocked(w, r, v) {
+		return
+	}
+	d, backend, ok := findDirectorBackend(w, r, v)
+	if !ok {
+		return
+	}
+	backends := d["backends"].([]string)
+	for _, b := range backends {
+		if b == backend {
+			writeError(w, r, http.StatusConflict, "Duplicate record", fmt.Sprintf("Backend '%s' is already in director '%s'", backend, d["name"]))
+			return
+		}
+	}
+	d["backends"] = append(append([]string{}, backends...), backend)
+	v.updatedAt = time.Now()
+	writeJSON(w, r, http.StatusOK, directorBackendJSON(svc, v, d["name"].(string), backend))
+}
+
+func (s *Server) removeDirectorBackend(w http.ResponseWriter, r *http.Request, svc *service, v *version) {
+	if !checkUnlocked(w, r, v) {
+		return
+	}
+	d, backend, ok := findDirectorBackend(w, r, v)
+	if !ok {
+		return
+	}
+	backends := []string{}
+	for _, b := range d["backends"].([]string) {
+		if b != backend {
+			backends = append(backends, b)
+		}
+	}
+	if len(backends) == len(d["backends"].([]string)) {
+		notFound(w, r, "Backend '%s' is not in director '%s'", backend, d["name"])
+		return
+	}
+	d["backends"] = backends
+	v.updatedAt = time.Now()
+	statusOK(w, r)
+}
@@ -0,0 +1,3 @@
+module github.com/ctdk/ghastly
+
+go 1.23
@@ -0,0 +1,233 @@
+package ghastly
+
+import (
+	"context"
+	"fmt"
+)
+
+// What a header object does to its header.
+type HeaderAction string
+
+const (
+	HeaderSet    HeaderAction = "set"
+	HeaderAppend HeaderAction = "append"
+	HeaderDelete HeaderAction = "delete"
+	// Replace the first match of the regex with the substitution.
+	HeaderRegex HeaderAction = "regex"
+	// Replace every match of the regex with the substitution.
+	HeaderRegexRepeat HeaderAction = "regex_repeat"
+)
+
+// Which headers a header object changes: the request's, the response from the
+// backend before it's cached, or the response sent to the client.
+type HeaderType string
+
+const (
+	HeaderRequest  HeaderType = "request"
+	HeaderCache    HeaderType = "cache"
+	HeaderResponse HeaderType = "response"
+)
+
+// A Header sets, changes, or removes a request or response header.
+type Header struct {
+	Name      string
+	Comment   string
+	ServiceId string
+	Version   int64
+	Locked    bool
+	Action    HeaderAction
+	Type      HeaderType
+	// The header to change, like "http.X-Forwarded-Host", and where its new
+	// value comes from, like "req.http.host" or "\"a string\"".
+	Dst          string
+	Src          string
+	Regex        string
+	Substitution string
+	// Leave the header alone if it's already set.
+	IgnoreIfSet bool
+	// Headers are applied in order of priority, lowest first.
+	Priority int64
+	// The names of the conditions deciding whether the header applies, if
+	// any.
+	RequestCondition  string
+	CacheCondition    string
+	ResponseCondition string
+	version           *Version
+}
+
+// Fields for creating or updating a header.
+type HeaderInput struct {
+	Name              *string       `form:"name"`
+	Comment           *string       `form:"comment"`
+	Action            *HeaderAction `form:"action"`
+	Type              *HeaderType   `form:"type"`
+	Dst               *string       `form:"dst"`
+	Src               *string       `form:"src"`
+	Regex             *string       `form:"regex"`
+	Substitution      *string       `form:"substitution"`
+	IgnoreIfSet       *bool         `form:"ignore_if_set"`
+	Priority          *int64        `form:"priority"`
+	RequestCondition  *string       `form:"request_condition"`
+	CacheCondition    *string       `form:"cache_condition"`
+	ResponseCondition *string       `form:"response_condition"`
+}
+
+// Check the header's action and type, if they're set, before sending them to
+// Fastly.
+func (hi *HeaderInput) check() error {
+	if hi == nil {
+		return nil
+	}
+	if hi.Action != nil {
+		switch *hi.Action {
+		case HeaderSet, HeaderAppend, HeaderDelete, HeaderRegex, HeaderRegexRepeat:
+		default:
+			err := fmt.Errorf("Unknown header action '%s'", *hi.Action)
+			return err
+		}
+	}
+	if hi.Type != nil {
+		switch *hi.Type {
+		case HeaderRequest, HeaderCache, HeaderResponse:
+		default:
+			err := fmt.Errorf("Unknown header type '%s'", *hi.Type)
+			return err
+		}
+	}
+	return nil
+}
+
+// Create a new header for a particular version of a service. The name, action,
+// type, and destination are required.
+func (v *Version) NewHeader(input *HeaderInput) (*Header, error) {
+	return v.NewHeaderContext(context.Background(), input)
+}
+
+// Like NewHeader, but takes a context.Context for the request.
+func (v *Version) NewHeaderContext(ctx context.Context, input *HeaderInput) (*Header, error) {
+	if err := input.check(); err != nil {
+		return nil, err
+	}
+	hData, err := createVersionObject[headerData](ctx, v, "header", input)
+	if err != nil {
+		return nil, err
+	}
+	return v.populateHeader(hData)
+}
+
+// List all headers associated with a version of a service.
+func (v *Version) ListHeaders() ([]*Header, error) {
+	return v.ListHeadersContext(context.Background())
+}
+
+// Like ListHeaders, but takes a context.Context for the request.
+func (v *Version) ListHeadersContext(ctx context.Context) ([]*Header, error) {
+	hData, err := listVersionObjects[headerData](ctx, v, "header")
+	if err != nil {
+		return nil, err
+	}
+	headers := make([]*Header, len(hData))
+	for i := range hData {
+		h, err := v.populateHeader(&hData[i])
+		if err != nil {
+			return nil, err
+		}
+		headers[i] = h
+	}
+	return headers, nil
+}
+
+// Get a header associated with this version. If the version has no such
+// header, the error returned satisfies IsNotFound.
+func (v *Version) GetHeader(name string) (*Header, error) {
+	return v.GetHeaderContext(context.Background(), name)
+}
+
+// Like GetHeader, but takes a context.Context for the request.
+func (v *Version) GetHeaderContext(ctx context.Context, name string) (*Header, error) {
+	hData, err := getVersionObject[headerData](ctx, v, "header", name)
+	if err != nil {
+		return nil, err
+	}
+	return v.populateHeader(hData)
+}
+
+// Update a header, for the version the header belongs to. Only the fields set
+// in input are changed, and the header is refreshed from Fastly's response.
+func (h *Header) Update(input *HeaderInput) error {
+	return h.UpdateContext(context.Background(), input)
+}
+
+// Like Update, but takes a context.Context for the request.
+func (h *Header) UpdateContext(ctx context.Context, input *HeaderInput) error {
+	if err := input.check(); err != nil {
+		return err
+	}
+	hData, err := updateVersionObject[headerData](ctx, h.version, "header", h.Name, input)
+	if err != nil {
+		return err
+	}
+	nh, err := h.version.populateHeader(hData)
+	if err != nil {
+		return err
+	}
+	*h = *nh
+	return nil
+}
+
+// Delete a header, for the version the header belongs to.
+func (h *Header) Delete() error {
+	return h.DeleteContext(context.Background())
+}
+
+// Like Delete, but takes a context.Context for the request.
+func (h *Header) DeleteContext(ctx context.Context) error {
+	return deleteVersionObject(ctx, h.version, "header", h.Name)
+}
+
+// The JSON representation of a header from the API.
+type headerData struct {
+	Name              string       `json:"name"`
+	Comment           string       `json:"comment"`
+	ServiceId         string       `json:"service_id"`
+	Version           int64        `json:"version"`
+	Locked            bool         `json:"locked"`
+	Action            HeaderAction `json:"action"`
+	Type              HeaderType   `json:"type"`
+	Dst               string       `json:"dst"`
+	Src               string       `json:"src"`
+	Regex             string       `json:"regex"`
+	Substitution      string       `json:"substitution"`
+	IgnoreIfSet       flexBool     `json:"ignore_if_set"`
+	Priority          flexInt      `json:"priority"`
+	RequestCondition  string       `json:"request_condition"`
+	CacheCondition    string       `json:"cache_condition"`
+	ResponseCondition string       `json:"response_condition"`
+}
+
+func (v *Version) populateHeader(hd *headerData) (*Header, error) {
+	if hd.Name == "" {
+		err := fmt.Errorf("Header data had no name")
+		return nil, err
+	}
+	h := &Header{
+		Name:              hd.Name,
+		Comment:           hd.Comment,
+		ServiceId:         hd.ServiceId,
+		Version:           hd.Version,
+		Locked:            hd.Locked,
+		Action:            hd.Action,
+		Type:              hd.Type,
+		Dst:               hd.Dst,
+		Src:               hd.Src,
+		Regex:             hd.Regex,
+		Substitution:      hd.Substitution,
+		IgnoreIfSet:       bool(hd.IgnoreIfSet),
+		Priority:          int64(hd.Priority),
+		RequestCondition:  hd.RequestCondition,
+		CacheCondition:    hd.CacheCondition,
+		ResponseCondition: hd.ResponseCondition,
+		version:           v,
+	}
+	return h, nil
+}
@@ -0,0 +1,192 @@
+package ghastly
+
+import (
+	"context"
+	"fmt"
+)
+
+// A Healthcheck is a request Fastly makes regularly to a backend to see if
+// it's healthy.
+type Healthcheck struct {
+	Name      string
+	Comment   string
+	ServiceId string
+	Version   int64
+	Locked    bool
+	Path      string
+	Host      string
+	Method    string
+	// The HTTP status the backend should respond with.
+	ExpectedResponse int64
+	HttpVersion      string
+	// How often to check, and how long to wait for a response, in
+	// milliseconds.
+	CheckInterval int64
+	Timeout       int64
+	// How many of the last Window checks have to pass for the backend to be
+	// healthy, and how many are counted as passing when the backend is first
+	// added.
+	Threshold int64
+	Window    int64
+	Initial   int64
+	version   *Version
+}
+
+// Fields for creating or updating a healthcheck.
+type HealthcheckInput struct {
+	Name             *string `form:"name"`
+	Comment          *string `form:"comment"`
+	Path             *string `form:"path"`
+	Host             *string `form:"host"`
+	Method           *string `form:"method"`
+	ExpectedResponse *int64  `form:"expected_response"`
+	HttpVersion      *string `form:"http_version"`
+	CheckInterval    *int64  `form:"check_interval"`
+	Timeout          *int64  `form:"timeout"`
+	Threshold        *int64  `form:"threshold"`
+	Window           *int64  `form:"window"`
+	Initial          *int64  `form:"initial"`
+}
+
+// Create a new healthcheck for a particular version of a service. The name is
+// required.
+func (v *Version) NewHealthcheck(input *HealthcheckInput) (*Healthcheck, error) {
+	return v.NewHealthcheckContext(context.Background(), input)
+}
+
+// Like NewHealthcheck, but takes a context.Context for the request.
+func (v *Version) NewHealthcheckContext(ctx context.Context, input *HealthcheckInput) (*Healthcheck, error) {
+	hData, err := createVersionObject[healthcheckData](ctx, v, "healthcheck", input)
+	if err != nil {
+		return nil, err
+	}
+	return v.populateHealthcheck(hData)
+}
+
+// List all healthchecks associated with a version of a service.
+func (v *Version) ListHealthchecks() ([]*Healthcheck, error) {
+	return v.ListHealthchecksContext(context.Background())
+}
+
+// Like ListHealthchecks, but takes a context.Context for the request.
+func (v *Version) ListHealthchecksContext(ctx context.Context) ([]*Healthcheck, error) {
+	hData, err := listVersionObjects[healthcheckData](ctx, v, "healthcheck")
+	if err != nil {
+		return nil, err
+	}
+	healthchecks := make([]*Healthcheck, len(hData))
+	for i := range hData {
+		h, err := v.populateHealthcheck(&hData[i])
+		if err != nil {
+			return nil, err
+		}
+		healthchecks[i] = h
+	}
+	return healthchecks, nil
+}
+
+// Get a healthcheck associated with this version. If the version has no such
+// healthcheck, the error returned satisfies IsNotFound.
+func (v *Version) GetHealthcheck(name string) (*Healthcheck, error) {
+	return v.GetHealthcheckContext(context.Background(), name)
+}
+
+// Like GetHealthcheck, but takes a context.Context for the request.
+func (v *Version) GetHealthcheckContext(ctx context.Context, name string) (*Healthcheck, error) {
+	hData, err := getVersionObject[healthcheckData](ctx, v, "healthcheck", name)
+	if err != nil {
+		return nil, err
+	}
+	return v.populateHealthcheck(hData)
+}
+
+// Update a healthcheck, for the version the healthcheck belongs to. Only the
+// fields set in input are changed, and the healthcheck is refreshed from
+// Fastly's response.
+func (h *Healthcheck) Update(input *HealthcheckInput) error {
+	return h.UpdateContext(context.Background(), input)
+}
+
+// Like Update, but takes a context.Context for the request.
+func (h *Healthcheck) UpdateContext(ctx context.Context, input *HealthcheckInput) error {
+	hData, err := updateVersionObject[healthcheckData](ctx, h.version, "healthcheck", h.Name, input)
+	if err != nil {
+		return err
+	}
+	nh, err := h.version.populateHealthcheck(hData)
+	if err != nil {
+		return err
+	}
+	*h = *nh
+	return nil
+}
+
+// Delete a healthcheck, for the version the healthcheck belongs to.
+func (h *Healthcheck) Delete() error {
+	return h.DeleteContext(context.Background())
+}
+
+// Like Delete, but takes a context.Context for the request.
+func (h *Healthcheck) DeleteContext(ctx context.Context) error {
+	return deleteVersionObject(ctx, h.version, "healthcheck", h.Name)
+}
+
+// Make sure the named healthcheck exists before attaching it to something, so
+// a typo is caught before it's saved. A nil or empty name detaches the
+// healthcheck, so that's fine.
+func (v *Version) checkHealthcheckExists(ctx context.Context, name *string) error {
+	if name == nil || *name == "" {
+		return nil
+	}
+	_, err := v.GetHealthcheckContext(ctx, *name)
+	if IsNotFound(err) {
+		err := fmt.Errorf("Healthcheck '%s' does not exist in version %d", *name, v.Number)
+		return err
+	}
+	return err
+}
+
+// The JSON representation of a healthcheck from the API.
+type healthcheckData struct {
+	Name             string `json:"name"`
+	Comment          string `json:"comment"`
+	ServiceId        string `json:"service_id"`
+	Version          int64  `json:"version"`
+	Locked           bool   `json:"locked"`
+	Path             string `json:"path"`
+	Host             string `json:"host"`
+	Method           string `json:"method"`
+	ExpectedResponse int64  `json:"expected_response"`
+	HttpVersion      string `json:"http_version"`
+	CheckInterval    int64  `json:"check_interval"`
+	Timeout          int64  `json:"timeout"`
+	Threshold        int64  `json:"threshold"`
+	Window           int64  `json:"window"`
+	Initial          int64  `json:"initial"`
+}
+
+func (v *Version) populateHealthcheck(hd *healthcheckData) (*Healthcheck, error) {
+	if hd.Name == "" {
+		err := fmt.Errorf("Healthcheck data had no name")
+		return nil, err
+	}
+	h := &Healthcheck{
+		Name:             hd.Name,
+		Comment:          hd.Comment,
+		ServiceId:        hd.ServiceId,
+		Version:          hd.Version,
+		Locked:           hd.Locked,
+		Path:             hd.Path,
+		Host:             hd.Host,
+		Method:           hd.Method,
+		ExpectedResponse: hd.ExpectedResponse,
+		HttpVersion:      hd.HttpVersion,
+		CheckInterval:    hd.CheckInterval,
+		Timeout:          hd.Timeout,
+		Threshold:        hd.Threshold,
+		Window:           hd.Window,
+		Initial:          hd.Initial,
+		version:          v,
+	}
+	return h, nil
+}
@@ -0,0 +1,139 @@
+package ghastly
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// When a version of a service was activated.
+type Activation struct {
+	Version     int64
+	ActivatedAt time.Time
+	// Where this activation was found: "event" if it came from Fastly's
+	// event log, or "version" if it was inferred from the version's
+	// timestamps because there was no event for it.
+	Source      string
+	Description string
+	UserId      string
+}
+
+// The JSON:API representation of events from the API.
+type eventsData struct {
+	Data []struct {
+		Id         string `json:"id"`
+		Attributes struct {
+			CreatedAt   string `json:"created_at"`
+			Description string `json:"description"`
+			EventType   string `json:"event_type"`
+			ServiceId   string `json:"service_id"`
+			UserId      string `json:"user_id"`
+			Metadata    struct {
+				Version int64 `json:"version"`
+			} `json:"metadata"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// Reconstruct when each version of the service was activated, newest first.
+// Fastly's event log is used where possible; versions that have been deployed
+// but don't show up there are included with their last update time as an
+// approximation.
+func (s *Service) ActivationHistory() ([]*Activation, error) {
+	return s.ActivationHistoryContext(context.Background())
+}
+
+// Like ActivationHistory, but takes a context.Context for the requests.
+func (s *Service) ActivationHistoryContext(ctx context.Context) ([]*Activation, error) {
+	history, err := s.activationEvents(ctx)
+	// Not every account can see the event log, so carry on without it
+	// if it's not there.
+	if err != nil && !IsNotFound(err) && !IsAuth(err) {
+		return nil, err
+	}
+	seen := make(map[int64]bool, len(history))
+	for _, a := range history {
+		seen[a.Version] = true
+	}
+	versions, err := s.ListVersionsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if (v.Deployed || v.Active) && !seen[v.Number] {
+			history = append(history, &Activation{Version: v.Number, ActivatedAt: v.UpdatedAt, Source: "version"})
+		}
+	}
+	sort.SliceStable(history, func(i, j int) bool { return history[i].ActivatedAt.After(history[j].ActivatedAt) })
+	return history, nil
+}
+
+func (s *Service) activationEvents(ctx context.Context) ([]*Activation, error) {
+	params := map[string]string{"filter[service_id]": s.Id, "filter[event_type]": "version.activate", "sort": "-created_at", "page[size]": "100"}
+	resp, err := s.ghastly.GetParamsContext(ctx, "/events", params)
+	if err != nil {
+		return nil, err
+	}
+	events, err := ParseJsonTyped[eventsData](resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]*Activation, 0, len(events.Data))
+	for _, e := range events.Data {
+		at, err := parseTime(e.Attributes.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, &Activation{Version: e.Attributes.Metadata.Version, ActivatedAt: at, Source: "event", Description: e.Attributes.Description, UserId: e.Attributes.UserId})
+	}
+	return history, nil
+}
+
+// Go back to the version that was most recently active before the current one,
+// activating it and returning it. Versions that were themselves rolled back,
+// meaning an older version was activated after them, are skipped, so rolling
+// back after a failed deploy doesn't bring the failed version back, and
+// rolling back twice goes back two activations.
+//
+// Any activation of an older version counts as a rollback here, whether it was
+// done by Rollback, by a failed Deploy, or by hand. So after deliberately
+// activating an older version, the newer versions it replaced won't be rolled
+// back to.
+func (s *Service) Rollback() (*Version, error) {
+	return s.RollbackContext(context.Background())
+}
+
+// Like Rollback, but takes a context.Context for the requests.
+func (s *Service) RollbackContext(ctx context.Context) (*Version, error) {
+	current, err := s.ghastly.fetchService(ctx, s.Id)
+	if err != nil {
+		return nil, err
+	}
+	s.setActiveVersion(current.ActiveVersion)
+	history, err := s.ActivationHistoryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the oldest version activated after the one being looked at
+	oldestLater := s.ActiveVersion
+	for _, a := range history {
+		superseded := oldestLater != 0 && a.Version > oldestLater
+		if oldestLater == 0 || a.Version < oldestLater {
+			oldestLater = a.Version
+		}
+		if a.Version == s.ActiveVersion || superseded {
+			continue
+		}
+		v, err := s.GetVersionContext(ctx, a.Version)
+		if err != nil {
+			return nil, err
+		}
+		if err = v.ActivateContext(ctx); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	err = fmt.Errorf("Service %s has no previously active version to roll back to", s.Name)
+	return nil, err
+}
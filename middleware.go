@@ -0,0 +1,101 @@
+package ghastly
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A RoundTripFunc sends an HTTP request and returns the response.
+type RoundTripFunc func(*http.Request) (*http.Response, error)
+
+// Middleware wraps the sending of every request made by a Client, including
+// logins and PURGE requests. It gets the next RoundTripFunc in the chain and
+// returns one that does whatever it needs to before and after calling it. The
+// response passed back up the chain is the raw response, before any API error
+// checking is done.
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+// Add middleware to the client. Middleware added first is the outermost, so it
+// sees the request first and the response last.
+func (c *Client) Use(mw ...Middleware) {
+	c.middleware = append(c.middleware, mw...)
+}
+
+func (c *Client) roundTripper(hc *http.Client) RoundTripFunc {
+	rt := RoundTripFunc(hc.Do)
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		rt = c.middleware[i](rt)
+	}
+	return rt
+}
+
+const redacted = "REDACTED"
+
+// Headers whose values should never show up in logs.
+var sensitiveHeaders = []string{"Fastly-Key", "Cookie", "Set-Cookie", "Authorization", "Proxy-Authorization"}
+
+// Form fields whose values should never show up in logs.
+var sensitiveFields = []string{"password", "ssl_client_key"}
+
+// Middleware that logs each request and response, along with how long the
+// request took. API keys, session cookies, passwords, and private keys are
+// masked.
+func LoggingMiddleware(logger *log.Logger) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			logger.Printf("--> %s %s %v%s", req.Method, req.URL, redactHeader(req.Header), redactBody(req))
+			start := time.Now()
+			resp, err := next(req)
+			elapsed := time.Since(start)
+			if err != nil {
+				logger.Printf("<-- %s %s error after %v: %s", req.Method, req.URL, elapsed, err)
+				return resp, err
+			}
+			logger.Printf("<-- %s %s %s in %v %v", req.Method, req.URL, resp.Status, elapsed, redactHeader(resp.Header))
+			return resp, err
+		}
+	}
+}
+
+func redactHeader(h http.Header) http.Header {
+	rh := h.Clone()
+	for _, k := range sensitiveHeaders {
+		if vals, ok := rh[http.CanonicalHeaderKey(k)]; ok {
+			for i := range vals {
+				vals[i] = redacted
+			}
+		}
+	}
+	return rh
+}
+
+// Return the request's form body with sensitive fields masked, for logging. The
+// body is only read through GetBody, so the request itself is unaffected.
+func redactBody(req *http.Request) string {
+	if req.GetBody == nil || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return ""
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return ""
+	}
+	values, err := url.ParseQuery(string(b))
+	if err != nil {
+		return ""
+	}
+	for _, f := range sensitiveFields {
+		if _, ok := values[f]; ok {
+			values.Set(f, redacted)
+		}
+	}
+	return " " + values.Encode()
+}
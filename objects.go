@@ -0,0 +1,114 @@
+package ghastly
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Backends, healthchecks, and the other objects attached to a version all live
+// under /service/<id>/version/<number>/<kind> and work the same way, so these
+// do the requests for them. T is the JSON representation of the kind of
+// object.
+
+func listVersionObjects[T any](ctx context.Context, v *Version, kind string) ([]T, error) {
+	url := v.baseURL(kind)
+	resp, err := v.service.ghastly.GetContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJsonArrayTyped[T](resp.Body)
+}
+
+func getVersionObject[T any](ctx context.Context, v *Version, kind, name string) (*T, error) {
+	url := v.baseURL(versionObjectPath(kind, name))
+	resp, err := v.service.ghastly.GetContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ParseJsonTyped[T](resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func createVersionObject[T any](ctx context.Context, v *Version, kind string, input interface{}) (*T, error) {
+	params, err := encodeParams(input)
+	if err != nil {
+		return nil, err
+	}
+	url := v.baseURL(kind)
+	resp, err := v.service.ghastly.PostFormContext(ctx, url, params)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ParseJsonTyped[T](resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func updateVersionObject[T any](ctx context.Context, v *Version, kind, name string, input interface{}) (*T, error) {
+	params, err := encodeParams(input)
+	if err != nil {
+		return nil, err
+	}
+	url := v.baseURL(versionObjectPath(kind, name))
+	resp, err := v.service.ghastly.PutContext(ctx, url, params, "application/x-www-form-urlencoded")
+	if err != nil {
+		return nil, err
+	}
+	data, err := ParseJsonTyped[T](resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func deleteVersionObject(ctx context.Context, v *Version, kind, name string) error {
+	url := v.baseURL(versionObjectPath(kind, name))
+	resp, err := v.service.ghastly.DeleteContext(ctx, url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Fastly sends some numbers, like condition and header priorities, as strings.
+// This accepts either.
+type flexInt int64
+
+func (f *flexInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*f = 0
+		return nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		err := fmt.Errorf("Can't read %s as a number: %s", b, err)
+		return err
+	}
+	*f = flexInt(i)
+	return nil
+}
+
+// Likewise, some booleans, like a header's ignore_if_set, come as "0" or "1".
+type flexBool bool
+
+func (f *flexBool) UnmarshalJSON(b []byte) error {
+	switch strings.Trim(string(b), `"`) {
+	case "1", "true":
+		*f = true
+	case "0", "false", "", "null":
+		*f = false
+	default:
+		err := fmt.Errorf("Can't read %s as a boolean", b)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package ghastly
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"time"
+)
+
+// Options for creating a new ghastly object. Either ApiKey or User and
+// Password must be set; if ApiKey is set, it is used and no login is done.
+type Options struct {
+	// Fastly API key to authenticate with.
+	ApiKey string
+	// User name and password to log in with, if not using an API key.
+	User     string
+	Password string
+	// Base URL of the Fastly API. Defaults to https://api.fastly.com.
+	BaseURL string
+	// Base URL that PURGE requests for individual URLs are sent to.
+	// Defaults to https://api.fastly.com/.
+	PurgeBaseURL string
+	// HTTP client to use for API calls. If it has no cookie jar and the
+	// session is logged in with a user name and password, a copy of it
+	// with a jar is used. PURGE requests use their own client, since they
+	// need a special transport.
+	HTTPClient *http.Client
+	// Timeout for requests. Zero means no timeout beyond whatever
+	// HTTPClient already has.
+	Timeout time.Duration
+	// User-Agent header to send with requests.
+	UserAgent string
+	// Logger for reporting retries, throttling, and the like. If nil,
+	// nothing is logged.
+	Logger *log.Logger
+	// How to retry requests that fail for transient reasons.
+	Retry *RetryPolicy
+	// Wait for the rate limit window to reset instead of going over it.
+	Throttle bool
+	// Middleware to wrap every request with, including the login.
+	Middleware []Middleware
+	// Called after logging in again because the session expired.
+	OnRelogin func(err error)
+}
+
+const (
+	defaultBaseURL      = "https://api.fastly.com"
+	defaultPurgeBaseURL = "https://api.fastly.com/"
+)
+
+// Initialize a new ghastly object with the given options, logging in if
+// needed.
+func NewFromOptions(opts *Options) (*Ghastly, error) {
+	return NewFromOptionsContext(context.Background(), opts)
+}
+
+// Like NewFromOptions, but takes a context.Context for the login request.
+func NewFromOptionsContext(ctx context.Context, opts *Options) (*Ghastly, error) {
+	if opts == nil {
+		err := fmt.Errorf("No options given")
+		return nil, err
+	}
+	if opts.ApiKey == "" && opts.User == "" {
+		err := fmt.Errorf("Either an API key or a user name must be given")
+		return nil, err
+	}
+	client := new(Client)
+	client.ApiKey = opts.ApiKey
+	client.BaseUrl = opts.BaseURL
+	if client.BaseUrl == "" {
+		client.BaseUrl = defaultBaseURL
+	}
+	client.PurgeBaseUrl = opts.PurgeBaseURL
+	if client.PurgeBaseUrl == "" {
+		client.PurgeBaseUrl = defaultPurgeBaseURL
+	}
+	purgeURL, err := url.Parse(client.PurgeBaseUrl)
+	if err != nil {
+		return nil, err
+	}
+	if purgeURL.Scheme == "" || purgeURL.Host == "" {
+		err = fmt.Errorf("Invalid purge base URL '%s'", client.PurgeBaseUrl)
+		return nil, err
+	}
+	client.UserAgent = opts.UserAgent
+	client.Logger = opts.Logger
+	client.Retry = opts.Retry
+	client.Throttle = opts.Throttle
+	client.Use(opts.Middleware...)
+	client.OnRelogin = opts.OnRelogin
+
+	client.Http = &http.Client{}
+	if opts.HTTPClient != nil {
+		// shallow copy so the caller's client isn't modified
+		hc := *opts.HTTPClient
+		client.Http = &hc
+	}
+	if opts.Timeout != 0 {
+		client.Http.Timeout = opts.Timeout
+	}
+	if client.ApiKey == "" && client.Http.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+		client.Http.Jar = jar
+	}
+	client.PurgeHttp = &http.Client{Jar: client.Http.Jar, Timeout: client.Http.Timeout}
+	client.PurgeHttp.Transport = &Transport{Proxy: http.ProxyFromEnvironment, PurgeBaseURL: purgeURL}
+
+	if client.ApiKey == "" {
+		// The credentials are kept for logging in again when the
+		// session expires.
+		client.User = opts.User
+		client.Password = opts.Password
+		if err := client.login(ctx, opts.User, opts.Password); err != nil {
+			return nil, err
+		}
+	}
+	return &Ghastly{Client: client}, nil
+}
+
+// The options that New accepts in its map, and how they map onto Options.
+var mapOptions = map[string]func(*Options, string){
+	"api_key":        func(o *Options, v string) { o.ApiKey = v },
+	"user":           func(o *Options, v string) { o.User = v },
+	"password":       func(o *Options, v string) { o.Password = v },
+	"base_url":       func(o *Options, v string) { o.BaseURL = v },
+	"purge_base_url": func(o *Options, v string) { o.PurgeBaseURL = v },
+	"user_agent":     func(o *Options, v string) { o.UserAgent = v },
+}
+
+func optionsFromMap(opts map[string]string) (*Options, error) {
+	o := new(Options)
+	for k, v := range opts {
+		set, ok := mapOptions[k]
+		if !ok {
+			err := fmt.Errorf("Unknown option '%s'", k)
+			return nil, err
+		}
+		set(o, v)
+	}
+	return o, nil
+}
@@ -0,0 +1,82 @@
+package ghastly
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+)
+
+// The typed inputs for creating and updating things (DomainInput, ServiceInput,
+// and so on) use pointer fields, so that only the fields that are set get sent.
+// Fields left nil are left alone on update. These helpers make the pointers.
+
+// Return a pointer to the string s, for setting an input field.
+func String(s string) *string {
+	return &s
+}
+
+// Return a pointer to the int64 i, for setting an input field.
+func Int(i int64) *int64 {
+	return &i
+}
+
+// Return a pointer to the bool b, for setting an input field.
+func Bool(b bool) *bool {
+	return &b
+}
+
+// Return a pointer to v, for setting input fields with types of their own, like
+// DirectorType.
+func Ptr[T any](v T) *T {
+	return &v
+}
+
+// Turn an input struct into form values, using the field's "form" tag as the
+// parameter name. Nil fields are skipped.
+func encodeParams(input interface{}) (url.Values, error) {
+	values := url.Values{}
+	rv := reflect.ValueOf(input)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return values, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		err := fmt.Errorf("Can't encode a %s as parameters", rv.Kind())
+		return nil, err
+	}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		name := rt.Field(i).Tag.Get("form")
+		if name == "" {
+			continue
+		}
+		f := rv.Field(i)
+		if f.Kind() != reflect.Pointer {
+			err := fmt.Errorf("Field %s of %s is not a pointer", rt.Field(i).Name, rt.Name())
+			return nil, err
+		}
+		if f.IsNil() {
+			continue
+		}
+		switch e := f.Elem(); e.Kind() {
+		case reflect.String:
+			values.Set(name, e.String())
+		case reflect.Int, reflect.Int64:
+			values.Set(name, strconv.FormatInt(e.Int(), 10))
+		case reflect.Bool:
+			// Fastly's form parameters take booleans as 1 and 0
+			if e.Bool() {
+				values.Set(name, "1")
+			} else {
+				values.Set(name, "0")
+			}
+		default:
+			err := fmt.Errorf("Field %s of %s has unsupported type %s", rt.Field(i).Name, rt.Name(), e.Type())
+			return nil, err
+		}
+	}
+	return values, nil
+}
@@ -1,39 +1,56 @@
 package ghastly
 
 import (
+	"context"
 	"fmt"
 )
 
+// The JSON reply to a purge request.
+type purgeData struct {
+	Status string `json:"status"`
+	Id     string `json:"id"`
+}
+
 // Purge a URL from the CDN.
 func (g *Ghastly) PurgeURL(url string) (string, error) {
-	resp, err := g.Purge(url)
+	return g.PurgeURLContext(context.Background(), url)
+}
+
+// Like PurgeURL, but takes a context.Context for the request.
+func (g *Ghastly) PurgeURLContext(ctx context.Context, url string) (string, error) {
+	resp, err := g.PurgeContext(ctx, url)
 	if err != nil {
 		return "", err
 	}
-	pData, err := ParseJson(resp.Body)
+	pData, err := ParseJsonTyped[purgeData](resp.Body)
 	if err != nil {
 		return "", err
 	}
-	if pData["status"].(string) != "ok" {
-		err = fmt.Errorf("Status was not ok with purging '%s'. The content of the reply was %v.", url, pData)
+	if pData.Status != "ok" {
+		err = fmt.Errorf("Status was not ok with purging '%s'. The content of the reply was %+v.", url, pData)
 		return "", err
 	}
-	return pData["id"].(string), nil
+	return pData.Id, nil
 }
 
 // Purge everything from a service.
 func (s *Service) PurgeAll() error {
+	return s.PurgeAllContext(context.Background())
+}
+
+// Like PurgeAll, but takes a context.Context for the request.
+func (s *Service) PurgeAllContext(ctx context.Context) error {
 	purl := s.TaskURL("purge_all")
-	resp, err := s.ghastly.Post(purl, "application/json", nil)
+	resp, err := s.ghastly.PostContext(ctx, purl, "application/json", nil)
 	if err != nil {
 		return err
 	}
-	pData, err := ParseJson(resp.Body)
+	pData, err := ParseJsonTyped[purgeData](resp.Body)
 	if err != nil {
 		return err
 	}
-	if pData["status"].(string) != "ok" {
-		err = fmt.Errorf("Status was not ok with purging all items from service %s. The content of the reply was %v.", s.Name, pData)
+	if pData.Status != "ok" {
+		err = fmt.Errorf("Status was not ok with purging all items from service %s. The content of the reply was %+v.", s.Name, pData)
 		return err
 	}
 	return nil
@@ -41,18 +58,23 @@ func (s *Service) PurgeAll() error {
 
 // Purge a service of items tagged with a particular key.
 func (s *Service) PurgeKey(key string) error {
+	return s.PurgeKeyContext(context.Background(), key)
+}
+
+// Like PurgeKey, but takes a context.Context for the request.
+func (s *Service) PurgeKeyContext(ctx context.Context, key string) error {
 	pkey := fmt.Sprintf("purge/%s", key)
 	purl := s.TaskURL(pkey)
-	resp, err := s.ghastly.Post(purl, "application/json", nil)
+	resp, err := s.ghastly.PostContext(ctx, purl, "application/json", nil)
 	if err != nil {
 		return err
 	}
-	pData, err := ParseJson(resp.Body)
+	pData, err := ParseJsonTyped[purgeData](resp.Body)
 	if err != nil {
 		return err
 	}
-	if pData["status"].(string) != "ok" {
-		err = fmt.Errorf("Status was not ok with purging items keyed with %s from service %s. The content of the reply was %v.", key, s.Name, pData)
+	if pData.Status != "ok" {
+		err = fmt.Errorf("Status was not ok with purging items keyed with %s from service %s. The content of the reply was %+v.", key, s.Name, pData)
 		return err
 	}
 	return nil
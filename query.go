@@ -0,0 +1,124 @@
+package ghastly
+
+import (
+	"context"
+	"iter"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A query for services, matched on the client side. Every condition that's set
+// has to match for a service to be included; the zero value matches every
+// service.
+type ServiceQuery struct {
+	// Shell style glob to match the name against, like "team-*-app".
+	NameGlob string
+	// Regular expression to match the name against.
+	NameRegexp *regexp.Regexp
+	// Text the service's comment must contain.
+	CommentContains string
+	// Customer the service must belong to.
+	CustomerId string
+	// Only match services with an active version.
+	HasActiveVersion bool
+	// Only match services updated at or after this time.
+	UpdatedSince time.Time
+	// How many services to fetch from Fastly at once. Defaults to 100.
+	PageSize int
+}
+
+const defaultPageSize = 100
+
+// Returns true if the service matches the query.
+func (q *ServiceQuery) Match(s *Service) bool {
+	if q.NameGlob != "" {
+		if ok, _ := path.Match(q.NameGlob, s.Name); !ok {
+			return false
+		}
+	}
+	if q.NameRegexp != nil && !q.NameRegexp.MatchString(s.Name) {
+		return false
+	}
+	if q.CommentContains != "" && !strings.Contains(s.Comment, q.CommentContains) {
+		return false
+	}
+	if q.CustomerId != "" && s.CustomerId != q.CustomerId {
+		return false
+	}
+	if q.HasActiveVersion && s.ActiveVersion == 0 {
+		return false
+	}
+	if !q.UpdatedSince.IsZero() && s.UpdatedAt.Before(q.UpdatedSince) {
+		return false
+	}
+	return true
+}
+
+// Find the services matching the query, fetching them from Fastly a page at a
+// time as the results are iterated over. If there's an error, it's yielded
+// with a nil service and the iteration stops.
+//
+//	for s, err := range g.QueryServices(&ghastly.ServiceQuery{NameGlob: "team-prod-*"}) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(s.Name)
+//	}
+func (g *Ghastly) QueryServices(q *ServiceQuery) iter.Seq2[*Service, error] {
+	return g.QueryServicesContext(context.Background(), q)
+}
+
+// Like QueryServices, but takes a context.Context for the requests.
+func (g *Ghastly) QueryServicesContext(ctx context.Context, q *ServiceQuery) iter.Seq2[*Service, error] {
+	return func(yield func(*Service, error) bool) {
+		if q.NameGlob != "" {
+			if _, err := path.Match(q.NameGlob, ""); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+		perPage := q.PageSize
+		if perPage <= 0 {
+			perPage = defaultPageSize
+		}
+		for page := 1; ; page++ {
+			services, err := g.listServicesPage(ctx, page, perPage)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, s := range services {
+				if q.Match(s) && !yield(s, nil) {
+					return
+				}
+			}
+			if len(services) < perPage {
+				return
+			}
+		}
+	}
+}
+
+func (g *Ghastly) listServicesPage(ctx context.Context, page, perPage int) ([]*Service, error) {
+	params := map[string]string{"page": strconv.Itoa(page), "per_page": strconv.Itoa(perPage)}
+	resp, err := g.GetParamsContext(ctx, "/service", params)
+	if err != nil {
+		return nil, err
+	}
+	servicesData, err := ParseJsonArrayTyped[serviceData](resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	services := make([]*Service, len(servicesData))
+	for i := range servicesData {
+		ss, err := g.populateService(&servicesData[i])
+		if err != nil {
+			return nil, err
+		}
+		services[i] = ss
+	}
+	return services, nil
+}
@@ -0,0 +1,66 @@
+package ghastly
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The state of Fastly's rate limit for API calls that modify things, as of the
+// last response that reported it.
+type RateLimit struct {
+	// How many more modifying calls may be made before the window resets.
+	Remaining int
+	// When the current rate limit window resets.
+	Reset time.Time
+	// When this rate limit information was received. If this is the zero
+	// time, no response with rate limit headers has been seen yet.
+	SeenAt time.Time
+}
+
+// Return the last rate limit state seen in a response from Fastly.
+func (c *Client) RateLimit() RateLimit {
+	c.rlMu.Lock()
+	defer c.rlMu.Unlock()
+	return c.rateLimit
+}
+
+func (c *Client) recordRateLimit(header http.Header) {
+	rem := header.Get("Fastly-RateLimit-Remaining")
+	if rem == "" {
+		return
+	}
+	remaining, err := strconv.Atoi(rem)
+	if err != nil {
+		return
+	}
+	rl := RateLimit{Remaining: remaining, SeenAt: time.Now()}
+	if reset, err := strconv.ParseInt(header.Get("Fastly-RateLimit-Reset"), 10, 64); err == nil {
+		rl.Reset = time.Unix(reset, 0)
+	}
+	c.rlMu.Lock()
+	c.rateLimit = rl
+	c.rlMu.Unlock()
+}
+
+// If the client is set to throttle itself and the rate limit has been used up,
+// wait until the window resets before letting a modifying request through.
+func (c *Client) throttle(ctx context.Context, method string) error {
+	if !c.Throttle {
+		return nil
+	}
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return nil
+	}
+	rl := c.RateLimit()
+	if rl.SeenAt.IsZero() || rl.Remaining > 0 {
+		return nil
+	}
+	wait := time.Until(rl.Reset)
+	if wait > 0 {
+		c.logf("rate limit used up, waiting %v for it to reset", wait)
+	}
+	return sleepContext(ctx, wait)
+}
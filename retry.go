@@ -0,0 +1,122 @@
+package ghastly
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// A RetryPolicy describes how a Client retries requests that fail for
+// transient reasons, like a 503 from Fastly or a connection reset. By default
+// only idempotent requests are retried; POSTs can be retried on a per-call
+// basis by using a context made with RetryPost.
+type RetryPolicy struct {
+	// The maximum number of times to try a request, including the first
+	// try. Values less than 2 disable retrying.
+	MaxAttempts int
+	// The delay before the first retry. It doubles with each retry after
+	// that, and some random jitter is applied.
+	MinBackoff time.Duration
+	// The longest delay between retries, not counting any longer delay
+	// requested by Fastly with a Retry-After header.
+	MaxBackoff time.Duration
+}
+
+// A reasonable retry policy for most uses.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 4, MinBackoff: 250 * time.Millisecond, MaxBackoff: 10 * time.Second}
+
+type retryPostKey struct{}
+
+// Return a copy of the context that allows POST requests made with it to be
+// retried by the client's RetryPolicy. Only use this with calls where it is
+// safe for Fastly to see the POST more than once.
+func RetryPost(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryPostKey{}, true)
+}
+
+func (r *RetryPolicy) maxAttempts(request *http.Request) int {
+	if r == nil || r.MaxAttempts < 2 {
+		return 1
+	}
+	// can't retry a request if the body can't be sent again
+	if request.Body != nil && request.Body != http.NoBody && request.GetBody == nil {
+		return 1
+	}
+	switch request.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE", "PURGE":
+		return r.MaxAttempts
+	case "POST":
+		if ok, _ := request.Context().Value(retryPostKey{}).(bool); ok {
+			return r.MaxAttempts
+		}
+	}
+	return 1
+}
+
+// How long to wait before the next try, after the given attempt failed with
+// err.
+func (r *RetryPolicy) backoff(attempt int, err error) time.Duration {
+	wait := r.MinBackoff << uint(attempt-1)
+	if wait > r.MaxBackoff || wait <= 0 {
+		wait = r.MaxBackoff
+	}
+	if wait > 0 {
+		// jitter between half and all of the backoff
+		wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if after := retryAfter(apiErr.Header); after > wait {
+			wait = after
+		}
+	}
+	return wait
+}
+
+func retryAfter(header http.Header) time.Duration {
+	ra := header.Get("Retry-After")
+	if ra == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(ra); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(ra); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func isRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
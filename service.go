@@ -1,7 +1,9 @@
 package ghastly
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"time"
 )
 
@@ -16,100 +18,193 @@ type Service struct {
 	ActiveVersion int64
 	UpdatedAt     time.Time
 	CreatedAt     time.Time
-	versions      map[int]*Version
+	versions      map[int64]*Version
 	ghastly       *Ghastly
+	mu            sync.Mutex
 }
 
-// Get a service with the ID string.
+// Fields for creating or updating a service.
+type ServiceInput struct {
+	Name    *string `form:"name"`
+	Comment *string `form:"comment"`
+}
+
+// Get a service with the ID string. If there is no such service, the error
+// returned satisfies IsNotFound. If caching is on, a cached copy of the service
+// may be returned.
 func (g *Ghastly) GetService(id string) (*Service, error) {
+	return g.GetServiceContext(context.Background(), id)
+}
+
+// Like GetService, but takes a context.Context for the request.
+func (g *Ghastly) GetServiceContext(ctx context.Context, id string) (*Service, error) {
+	if s, ok := g.lookups().get(serviceIdKey(id)); ok {
+		return s.(*Service), nil
+	}
+	s, err := g.fetchService(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	g.cacheService(s)
+	return s, nil
+}
+
+// Get a service from Fastly, skipping the cache.
+func (g *Ghastly) fetchService(ctx context.Context, id string) (*Service, error) {
 	url := makeServiceURL(id)
-	resp, err := g.Get(url)
+	resp, err := g.GetContext(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	sData, err := ParseJson(resp.Body)
+	sData, err := ParseJsonTyped[serviceData](resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	return g.populateService(sData)
+	return g.populateService(&sData)
 }
 
 // List the current services.
 func (g *Ghastly) ListServices() ([]*Service, error) {
-	resp, err := g.Get("/service")
+	return g.ListServicesContext(context.Background())
+}
+
+// Like ListServices, but takes a context.Context for the request.
+func (g *Ghastly) ListServicesContext(ctx context.Context) ([]*Service, error) {
+	resp, err := g.GetContext(ctx, "/service")
 	if err != nil {
 		return nil, err
 	}
 
-	servicesData, err := ParseJsonArray(resp.Body)
+	servicesData, err := ParseJsonArrayTyped[serviceData](resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
 	services := make([]*Service, len(servicesData))
-	for i, v := range servicesData {
-		ss, err := g.populateService(v.(map[string]interface{}))
+	for i := range servicesData {
+		ss, err := g.populateService(&servicesData[i])
 		if err != nil {
 			return nil, err
 		}
 		services[i] = ss
 	}
 	return services, nil
-
-	return nil, nil
 }
 
 // Search for a service by name. The API does not appear to permit wildcards at
-// this time.
+// this time. If no service has that name, the error returned satisfies
+// IsNotFound. If caching is on, a cached copy of the service may be returned.
 func (g *Ghastly) SearchServices(searchStr string) (*Service, error) {
+	return g.SearchServicesContext(context.Background(), searchStr)
+}
+
+// Like SearchServices, but takes a context.Context for the request.
+func (g *Ghastly) SearchServicesContext(ctx context.Context, searchStr string) (*Service, error) {
+	if s, ok := g.lookups().get(serviceNameKey(searchStr)); ok {
+		return s.(*Service), nil
+	}
 	params := map[string]string{"name": searchStr}
 	searchURL := makeServiceURL("search")
-	resp, err := g.GetParams(searchURL, params)
+	resp, err := g.GetParamsContext(ctx, searchURL, params)
 	if err != nil {
 		return nil, err
 	}
 
-	s, err := ParseJson(resp.Body)
-	return g.populateService(s)
+	sData, err := ParseJsonTyped[serviceData](resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	s, err := g.populateService(&sData)
+	if err != nil {
+		return nil, err
+	}
+	g.cacheService(s)
+	return s, nil
 }
 
 // Create a new service.
 func (g *Ghastly) NewService(name string) (*Service, error) {
-	params := map[string]string{"name": name}
-	resp, err := g.PostFormParams("/service", params)
+	return g.NewServiceContext(context.Background(), name)
+}
+
+// Like NewService, but takes a context.Context for the request.
+func (g *Ghastly) NewServiceContext(ctx context.Context, name string) (*Service, error) {
+	return g.NewServiceWithInputContext(ctx, &ServiceInput{Name: String(name)})
+}
+
+// Create a new service with a name and, optionally, a comment. The name is
+// required.
+func (g *Ghastly) NewServiceWithInput(input *ServiceInput) (*Service, error) {
+	return g.NewServiceWithInputContext(context.Background(), input)
+}
+
+// Like NewServiceWithInput, but takes a context.Context for the request.
+func (g *Ghastly) NewServiceWithInputContext(ctx context.Context, input *ServiceInput) (*Service, error) {
+	params, err := encodeParams(input)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.PostFormContext(ctx, "/service", params)
 	if err != nil {
 		return nil, err
 	}
-	sData, err := ParseJson(resp.Body)
+	g.lookups().invalidate(nil, serviceNameKey(params.Get("name")))
+	sData, err := ParseJsonTyped[serviceData](resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	return g.populateService(sData)
+	return g.populateService(&sData)
 }
 
-func (g *Ghastly) populateService(serviceData map[string]interface{}) (*Service, error) {
+// The JSON representation of a service from the API.
+type serviceData struct {
+	Id         string        `json:"id"`
+	Name       string        `json:"name"`
+	CustomerId string        `json:"customer_id"`
+	PublishKey string        `json:"publish_key"`
+	Comment    string        `json:"comment"`
+	Version    int64         `json:"version"`
+	Versions   []versionData `json:"versions"`
+	CreatedAt  string        `json:"created_at"`
+	UpdatedAt  string        `json:"updated_at"`
+}
+
+func (g *Ghastly) populateService(sd *serviceData) (*Service, error) {
+	if sd.Id == "" {
+		err := fmt.Errorf("Service data had no id")
+		return nil, err
+	}
 	s := new(Service)
-	s.Id = serviceData["id"].(string)
-	s.Name = serviceData["name"].(string)
-	s.CustomerId = serviceData["customer_id"].(string)
-	s.PublishKey, _ = serviceData["publish_key"].(string)
-	s.Comment, _ = serviceData["comment"].(string)
+	s.Id = sd.Id
+	s.Name = sd.Name
+	s.CustomerId = sd.CustomerId
+	s.PublishKey = sd.PublishKey
+	s.Comment = sd.Comment
 	s.ghastly = g
 
-	if cc, ok := serviceData["created_at"].(string); ok {
-		createdAt, err := time.Parse(time.RFC3339, cc)
-		if err != nil {
-			return nil, err
-		}
-		s.CreatedAt = createdAt
+	// "version" is the current version on some calls rather than the
+	// active one, so it's only used when there's no list of versions to
+	// say which one is active, if any.
+	if sd.Versions == nil {
+		s.ActiveVersion = sd.Version
 	}
-	if uc, ok := serviceData["created_at"].(string); ok {
-		updatedAt, err := time.Parse(time.RFC3339, uc)
+	for i := range sd.Versions {
+		v, err := s.populateVersion(&sd.Versions[i])
 		if err != nil {
 			return nil, err
 		}
-		s.UpdatedAt = updatedAt
+		if v.Active {
+			s.ActiveVersion = v.Number
+		}
+	}
+
+	var err error
+	if s.CreatedAt, err = parseTime(sd.CreatedAt); err != nil {
+		return nil, err
+	}
+	if s.UpdatedAt, err = parseTime(sd.UpdatedAt); err != nil {
+		return nil, err
 	}
 
 	return s, nil
@@ -117,11 +212,18 @@ func (g *Ghastly) populateService(serviceData map[string]interface{}) (*Service,
 
 // Delete a service and everything attached to it.
 func (s *Service) Delete() error {
+	return s.DeleteContext(context.Background())
+}
+
+// Like Delete, but takes a context.Context for the request.
+func (s *Service) DeleteContext(ctx context.Context) error {
 	url := makeServiceURL(s.Id)
-	_, err := s.ghastly.Delete(url)
+	resp, err := s.ghastly.DeleteContext(ctx, url)
 	if err != nil {
 		return err
 	}
+	resp.Body.Close()
+	s.ghastly.invalidateService(s.Id, true)
 	return nil
 }
 
@@ -132,29 +234,41 @@ func (s *Service) TaskURL(taskPath string) string {
 	return url
 }
 
-// Get detailed information about a service.
-func (s *Service) Details() (*Service, error) {
-	detailURL := makeServiceURL(fmt.Sprintf("%s/details", s.Id))
-	resp, err := s.ghastly.Get(detailURL)
+// Update a service's name or comment. Only the fields set in input are changed,
+// and the service is refreshed from Fastly's response.
+func (s *Service) Update(input *ServiceInput) error {
+	return s.UpdateContext(context.Background(), input)
+}
+
+// Like Update, but takes a context.Context for the request.
+func (s *Service) UpdateContext(ctx context.Context, input *ServiceInput) error {
+	params, err := encodeParams(input)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	sData, err := ParseJson(resp.Body)
+	url := makeServiceURL(s.Id)
+	resp, err := s.ghastly.PutContext(ctx, url, params, "application/x-www-form-urlencoded")
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return s.ghastly.populateService(sData)
-}
-
-// Update a service's attributes with a map of strings for params. Currently the
-// only meaningful attribute that can be updated here is the service's name.
-func (s *Service) Update(params map[string]string) error {
-	url := makeServiceURL(s.Id)
-	_, err := s.ghastly.PutParams(url, params)
+	s.ghastly.invalidateService(s.Id, false)
+	sData, err := ParseJsonTyped[serviceData](resp.Body)
 	if err != nil {
-		return nil
+		return err
+	}
+	s.Name = sData.Name
+	s.Comment = sData.Comment
+	if sData.CustomerId != "" {
+		s.CustomerId = sData.CustomerId
+	}
+	if sData.PublishKey != "" {
+		s.PublishKey = sData.PublishKey
+	}
+	if updatedAt, err := parseTime(sData.UpdatedAt); err != nil {
+		return err
+	} else if !updatedAt.IsZero() {
+		s.UpdatedAt = updatedAt
 	}
-	s.Name = params["name"]
 	return nil
 }
 
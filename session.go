@@ -0,0 +1,49 @@
+package ghastly
+
+import (
+	"context"
+	"net/http"
+)
+
+type loggingInKey struct{}
+
+// Whether a failed request should be retried after logging in again. This is
+// only done for clients using a session from a user name and password, and
+// never for the login request itself. Only a 401 means the session expired; a
+// 403 means the user isn't allowed to do that, and logging in won't help.
+func (c *Client) canRelogin(request *http.Request, err error) bool {
+	if c.ApiKey != "" || c.User == "" || !hasStatus(err, http.StatusUnauthorized) {
+		return false
+	}
+	if ok, _ := request.Context().Value(loggingInKey{}).(bool); ok {
+		return false
+	}
+	return request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+}
+
+// Log in again with the client's saved credentials because a request sent
+// during login generation gen failed authentication. If another goroutine has
+// already logged in again since then, this returns without doing anything, so
+// a burst of failures only causes one login. The OnRelogin hook is called
+// after the lock is released, so it can make requests of its own.
+func (c *Client) relogin(ctx context.Context, gen uint64) error {
+	tried, err := c.reloginLocked(ctx, gen)
+	if tried && c.OnRelogin != nil {
+		c.OnRelogin(err)
+	}
+	return err
+}
+
+func (c *Client) reloginLocked(ctx context.Context, gen uint64) (bool, error) {
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+	if c.loginGen.Load() != gen {
+		return false, nil
+	}
+	c.logf("session expired, logging in again as %s", c.User)
+	err := c.login(ctx, c.User, c.Password)
+	if err == nil {
+		c.loginGen.Add(1)
+	}
+	return true, err
+}
@@ -0,0 +1,188 @@
+package ghastly
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A problem found in a condition's statement before sending it to Fastly.
+type StatementError struct {
+	Statement string
+	// The byte offset in the statement where the problem is.
+	Pos int
+	Msg string
+}
+
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("Bad condition statement at position %d: %s", e.Pos, e.Msg)
+}
+
+// The operators VCL allows in a condition. "+" joins strings.
+var statementOperators = map[string]bool{
+	"==": true,
+	"!=": true,
+	"~":  true,
+	"!~": true,
+	"<":  true,
+	">":  true,
+	"<=": true,
+	">=": true,
+	"&&": true,
+	"||": true,
+	"!":  true,
+	"+":  true,
+}
+
+const operatorBytes = "=!~<>&|+"
+
+// An open parenthesis, and whether it starts a function call's arguments.
+type statementParen struct {
+	pos  int
+	call bool
+}
+
+// Check a condition's VCL boolean expression for unbalanced parentheses,
+// unterminated strings, operators VCL doesn't have, and missing operators or
+// operands. This is a quick sanity check, not a VCL parser; Fastly will still
+// reject some statements that pass it when the version is validated. Any
+// problem is returned as a *StatementError.
+func CheckStatement(statement string) error {
+	fail := func(pos int, format string, v ...interface{}) error {
+		return &StatementError{Statement: statement, Pos: pos, Msg: fmt.Sprintf(format, v...)}
+	}
+	if strings.TrimSpace(statement) == "" {
+		return fail(0, "statement is empty")
+	}
+	var open []statementParen
+	// whether the last thing seen was an operand, where the last binary
+	// operator was, and where the last word ended, to tell function calls
+	// from parenthesized expressions
+	operand, justOpened := false, false
+	lastOp, lastOpPos := "", 0
+	wordEnd := -1
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			i++
+			continue
+		}
+		opened := false
+		switch {
+		case c == '(':
+			call := wordEnd == i
+			if operand && !call {
+				return fail(i, "missing operator before '('")
+			}
+			open = append(open, statementParen{pos: i, call: call})
+			operand, opened = false, true
+			i++
+		case c == ')':
+			if len(open) == 0 {
+				return fail(i, "unmatched ')'")
+			}
+			p := open[len(open)-1]
+			if !operand {
+				switch {
+				case justOpened && !p.call:
+					return fail(i, "empty parentheses")
+				case justOpened:
+				case lastOp != "" && lastOpPos > p.pos:
+					return fail(lastOpPos, "operator '%s' is missing its right-hand side", lastOp)
+				default:
+					return fail(i, "missing operand before ')'")
+				}
+			}
+			open = open[:len(open)-1]
+			operand = true
+			i++
+		case c == '{' && i+1 < len(statement) && statement[i+1] == '"':
+			if operand {
+				return fail(i, "missing operator")
+			}
+			end := strings.Index(statement[i+2:], "\"}")
+			if end < 0 {
+				return fail(i, "unterminated long string")
+			}
+			operand = true
+			i += end + 4
+		case c == '"':
+			if operand {
+				return fail(i, "missing operator")
+			}
+			end := strings.IndexAny(statement[i+1:], "\"\n")
+			if end < 0 || statement[i+1+end] == '\n' {
+				return fail(i, "unterminated string")
+			}
+			operand = true
+			i += end + 2
+		case strings.IndexByte(operatorBytes, c) >= 0:
+			j := i
+			for j < len(statement) && strings.IndexByte(operatorBytes, statement[j]) >= 0 {
+				j++
+			}
+			op := statement[i:j]
+			// "!" can start an operand, as in "!req.http.Cookie" or
+			// "a != !b"
+			if !statementOperators[op] && op[len(op)-1] == '!' && statementOperators[op[:len(op)-1]] {
+				j--
+				op = op[:len(op)-1]
+			}
+			if !statementOperators[op] {
+				return fail(i, "unknown operator '%s'", op)
+			}
+			if op == "!" {
+				if operand {
+					return fail(i, "missing operator before '!'")
+				}
+			} else {
+				if !operand {
+					return fail(i, "operator '%s' is missing its left-hand side", op)
+				}
+				lastOp, lastOpPos = op, i
+			}
+			operand = false
+			i = j
+		case isStatementWordByte(c):
+			if operand {
+				return fail(i, "missing operator")
+			}
+			j := i
+			for j < len(statement) && isStatementWordByte(statement[j]) {
+				j++
+			}
+			if statement[i:j] == "-" {
+				return fail(i, "unknown operator '-'")
+			}
+			operand = true
+			i, wordEnd = j, j
+		case c == ',':
+			if len(open) == 0 || !open[len(open)-1].call {
+				return fail(i, "',' outside of a function call")
+			}
+			if !operand {
+				return fail(i, "missing operand before ','")
+			}
+			operand = false
+			i++
+		default:
+			return fail(i, "unexpected character '%c'", c)
+		}
+		justOpened = opened
+	}
+	if len(open) > 0 {
+		return fail(open[len(open)-1].pos, "unmatched '('")
+	}
+	if !operand {
+		if lastOp != "" {
+			return fail(lastOpPos, "operator '%s' is missing its right-hand side", lastOp)
+		}
+		return fail(len(statement), "statement ends without an operand")
+	}
+	return nil
+}
+
+// Identifiers like req.http.X-Forwarded-For or client.geo.country_code,
+// function names, and numbers.
+func isStatementWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("._-:", c) >= 0
+}
@@ -19,6 +19,7 @@ package ghastly
 import (
 	"bufio"
 	"compress/gzip"
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"errors"
@@ -187,7 +188,7 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 	// host (for http or https), the http proxy, or the http proxy
 	// pre-CONNECTed to https server.  In any case, we'll be ready
 	// to send it requests.
-	pconn, err := t.getConn(cm)
+	pconn, err := t.getConn(req.Context(), cm)
 	if err != nil {
 		return nil, err
 	}
@@ -237,7 +238,8 @@ func (t *Transport) CloseIdleConnections() {
 }
 
 // CancelRequest cancels an in-flight request by closing its
-// connection.
+// connection. Requests made with a context are also cancelled this way when
+// the context is done.
 func (t *Transport) CancelRequest(req *http.Request) {
 	t.reqMu.Lock()
 	pc := t.reqConn[req]
@@ -413,7 +415,10 @@ func (t *Transport) dial(network, addr string) (c net.Conn, err error) {
 // specified in the connectMethod.  This includes doing a proxy CONNECT
 // and/or setting up TLS.  If this doesn't return an error, the persistConn
 // is ready to write requests to.
-func (t *Transport) getConn(cm *connectMethod) (*persistConn, error) {
+func (t *Transport) getConn(ctx context.Context, cm *connectMethod) (*persistConn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if pc := t.getIdleConn(cm); pc != nil {
 		return pc, nil
 	}
@@ -445,6 +450,14 @@ func (t *Transport) getConn(cm *connectMethod) (*persistConn, error) {
 			}
 		}()
 		return pc, nil
+	case <-ctx.Done():
+		// Same as above, but the caller's gone away.
+		go func() {
+			if v := <-dialc; v.err == nil {
+				t.putIdleConn(v.pc)
+			}
+		}()
+		return nil, ctx.Err()
 	}
 }
 
@@ -884,6 +897,7 @@ func (pc *persistConn) roundTrip(req *transportRequest) (resp *http.Response, er
 	pc.reqch <- requestAndChan{req.Request, resc, requestedGzip}
 
 	var re responseAndError
+	var ctxDone = req.Context().Done()
 	var pconnDeadCh = pc.closech
 	var failTicker <-chan time.Time
 	var respHeaderTimer <-chan time.Time
@@ -921,6 +935,10 @@ WaitResponse:
 			pc.close()
 			re = responseAndError{err: errors.New("net/http: timeout awaiting response headers")}
 			break WaitResponse
+		case <-ctxDone:
+			pc.close()
+			re = responseAndError{err: req.Context().Err()}
+			break WaitResponse
 		case re = <-resc:
 			break WaitResponse
 		}
@@ -0,0 +1,118 @@
+package ghastly
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// The result of validating a version's configuration. Only Errors make it
+// invalid; Infos holds any messages that are neither errors nor warnings.
+type Validation struct {
+	Status   string
+	Msg      string
+	Errors   []*ValidationMessage
+	Warnings []*ValidationMessage
+	Infos    []*ValidationMessage
+}
+
+// One message from validating a version.
+type ValidationMessage struct {
+	Type   string
+	Text   string
+	Detail string
+	Line   int
+}
+
+// Returns true if the version's configuration is valid.
+func (val *Validation) Valid() bool {
+	return val.Status == "ok" && len(val.Errors) == 0
+}
+
+// Returns an error describing what's wrong with the configuration, or nil if
+// it's valid. Warnings alone do not make it invalid.
+func (val *Validation) Err() error {
+	if val.Valid() {
+		return nil
+	}
+	msgs := make([]string, 0, len(val.Errors))
+	for _, m := range val.Errors {
+		msgs = append(msgs, m.String())
+	}
+	if len(msgs) == 0 && val.Msg != "" {
+		msgs = append(msgs, val.Msg)
+	}
+	err := fmt.Errorf("Version is not valid (status '%s'): %s", val.Status, strings.Join(msgs, "; "))
+	return err
+}
+
+func (m *ValidationMessage) String() string {
+	s := m.Text
+	if m.Line != 0 {
+		s = fmt.Sprintf("line %d: %s", m.Line, s)
+	}
+	if m.Detail != "" {
+		s = fmt.Sprintf("%s (%s)", s, m.Detail)
+	}
+	return s
+}
+
+// The JSON representation of a validation from the API. Errors and warnings
+// are plain strings; messages have more detail, when there are any.
+type validationData struct {
+	Status   string   `json:"status"`
+	Msg      string   `json:"msg"`
+	Errors   []string `json:"errors"`
+	Warnings []string `json:"warnings"`
+	Messages []struct {
+		Type   string `json:"type"`
+		Msg    string `json:"msg"`
+		Text   string `json:"text"`
+		Detail string `json:"detail"`
+		Line   int    `json:"line"`
+	} `json:"messages"`
+}
+
+// Validate this version's configuration, without activating it.
+func (v *Version) Validate() (*Validation, error) {
+	return v.ValidateContext(context.Background())
+}
+
+// Like Validate, but takes a context.Context for the request.
+func (v *Version) ValidateContext(ctx context.Context) (*Validation, error) {
+	url := v.baseURL("validate")
+	resp, err := v.service.ghastly.GetContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	vd, err := ParseJsonTyped[validationData](resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	val := &Validation{Status: vd.Status, Msg: vd.Msg}
+	if len(vd.Messages) > 0 {
+		for _, m := range vd.Messages {
+			text := m.Text
+			if text == "" {
+				text = m.Msg
+			}
+			vm := &ValidationMessage{Type: m.Type, Text: text, Detail: m.Detail, Line: m.Line}
+			switch strings.ToLower(m.Type) {
+			case "error":
+				val.Errors = append(val.Errors, vm)
+			case "warning":
+				val.Warnings = append(val.Warnings, vm)
+			default:
+				val.Infos = append(val.Infos, vm)
+			}
+		}
+		return val, nil
+	}
+	for _, e := range vd.Errors {
+		val.Errors = append(val.Errors, &ValidationMessage{Type: "error", Text: e})
+	}
+	for _, w := range vd.Warnings {
+		val.Warnings = append(val.Warnings, &ValidationMessage{Type: "warning", Text: w})
+	}
+	return val, nil
+}
@@ -1,7 +1,13 @@
 package ghastly
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -17,6 +23,8 @@ type Version struct {
 	Staging   bool
 	Deployed  bool
 	Network   VersionNetwork
+	CreatedAt time.Time
+	UpdatedAt time.Time
 	deletedAt time.Time
 	service   *Service
 }
@@ -30,79 +38,376 @@ type VersionNetwork struct {
 	CustomerId          string
 }
 
-func (s *Service) populateVersion(versionData map[string]interface{}) (*Version, error) {
-	active, _ := versionData["active"].(bool)
-	locked, _ := versionData["locked"].(bool)
-	testing, _ := versionData["testing"].(bool)
-	staging, _ := versionData["staging"].(bool)
-	deployed, _ := versionData["deployed"].(bool)
-	comment, _ := versionData["comment"].(string)
-	return &Version{Number: int64(versionData["number"].(float64)), ServiceId: versionData["service_id"].(string), Active: active, Locked: locked, Comment: comment, Testing: testing, Staging: staging, Deployed: deployed, service: s}, nil
+// The JSON representation of a version from the API.
+type versionData struct {
+	Number    int64           `json:"number"`
+	ServiceId string          `json:"service_id"`
+	Active    bool            `json:"active"`
+	Locked    bool            `json:"locked"`
+	Comment   string          `json:"comment"`
+	Testing   bool            `json:"testing"`
+	Staging   bool            `json:"staging"`
+	Deployed  bool            `json:"deployed"`
+	Network   json.RawMessage `json:"network"`
+	CreatedAt string          `json:"created_at"`
+	UpdatedAt string          `json:"updated_at"`
+	DeletedAt string          `json:"deleted_at"`
+}
+
+type versionNetworkData struct {
+	Name                string `json:"name"`
+	Description         string `json:"description"`
+	AvailableAll        bool   `json:"available_all"`
+	AvailableRestricted bool   `json:"available_restricted"`
+	AvailablePrivate    bool   `json:"available_private"`
+	CustomerId          string `json:"customer_id"`
+}
+
+func (s *Service) populateVersion(vd *versionData) (*Version, error) {
+	v := &Version{Number: vd.Number, ServiceId: vd.ServiceId, Active: vd.Active, Locked: vd.Locked, Comment: vd.Comment, Testing: vd.Testing, Staging: vd.Staging, Deployed: vd.Deployed, service: s}
+	if v.ServiceId == "" && s != nil {
+		v.ServiceId = s.Id
+	}
+	// The network is sometimes just a name, and sometimes a full object.
+	if len(vd.Network) > 0 {
+		var nd versionNetworkData
+		if err := json.Unmarshal(vd.Network, &nd); err == nil {
+			v.Network = VersionNetwork(nd)
+		} else if err := json.Unmarshal(vd.Network, &nd.Name); err == nil {
+			v.Network.Name = nd.Name
+		}
+	}
+	var err error
+	if v.CreatedAt, err = parseTime(vd.CreatedAt); err != nil {
+		return nil, err
+	}
+	if v.UpdatedAt, err = parseTime(vd.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if v.deletedAt, err = parseTime(vd.DeletedAt); err != nil {
+		return nil, err
+	}
+	if s != nil {
+		s.cacheVersion(v)
+	}
+	return v, nil
 }
 
 // Create a brand new, pristine version of a service, with nothing in it.
 func (s *Service) NewVersion() (*Version, error) {
+	return s.NewVersionContext(context.Background())
+}
+
+// Like NewVersion, but takes a context.Context for the request.
+func (s *Service) NewVersionContext(ctx context.Context) (*Version, error) {
 	params := map[string]string{"service": s.Id}
 	url := s.TaskURL("/version")
-	resp, err := s.ghastly.PostFormParams(url, params)
+	resp, err := s.ghastly.PostFormParamsContext(ctx, url, params)
 	if err != nil {
 		return nil, err
 	}
-	vData, err := ParseJson(resp.Body)
+	vData, err := ParseJsonTyped[versionData](resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.populateVersion(vData)
+	return s.populateVersion(&vData)
 }
 
-// Get a particular version of this service identified by the version number.
+// Get a particular version of this service identified by the version number. If
+// caching is on, a cached copy of the version may be returned.
 func (s *Service) GetVersion(number int64) (*Version, error) {
+	return s.GetVersionContext(context.Background(), number)
+}
+
+// Like GetVersion, but takes a context.Context for the request.
+func (s *Service) GetVersionContext(ctx context.Context, number int64) (*Version, error) {
+	if v, ok := s.ghastly.cachedVersion(s, number); ok {
+		s.cacheVersion(v)
+		return v, nil
+	}
 	u := fmt.Sprintf("/version/%d", number)
 	url := s.TaskURL(u)
-	resp, err := s.ghastly.Get(url)
+	resp, err := s.ghastly.GetContext(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	vData, err := ParseJson(resp.Body)
+	vData, err := ParseJsonTyped[versionData](resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.populateVersion(vData)
+	v, err := s.populateVersion(&vData)
+	if err != nil {
+		return nil, err
+	}
+	s.ghastly.cacheVersion(v)
+	return v, nil
+}
+
+// A VersionFilter decides whether ListVersions should include a version.
+type VersionFilter func(*Version) bool
+
+// Only list versions that are not locked.
+func OnlyUnlocked() VersionFilter {
+	return func(v *Version) bool {
+		return !v.Locked
+	}
+}
+
+// Only list versions numbered higher than n.
+func NewerThan(n int64) VersionFilter {
+	return func(v *Version) bool {
+		return v.Number > n
+	}
+}
+
+// Only list versions whose comment contains substr.
+func CommentContains(substr string) VersionFilter {
+	return func(v *Version) bool {
+		return strings.Contains(v.Comment, substr)
+	}
 }
 
-/*
-// List all versions belonging to a service.
-func (s *Service) ListVersions() ([]*Version, error) {
+// List the versions belonging to a service, in order, optionally limited to
+// the versions that pass all of the given filters. All of the service's
+// versions are cached on the service, whether they pass the filters or not.
+func (s *Service) ListVersions(filters ...VersionFilter) ([]*Version, error) {
+	return s.ListVersionsContext(context.Background(), filters...)
+}
 
+// Like ListVersions, but takes a context.Context for the request.
+func (s *Service) ListVersionsContext(ctx context.Context, filters ...VersionFilter) ([]*Version, error) {
+	url := s.TaskURL("version")
+	resp, err := s.ghastly.GetContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	vData, err := ParseJsonArrayTyped[versionData](resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]*Version, 0, len(vData))
+VersionLoop:
+	for i := range vData {
+		v, err := s.populateVersion(&vData[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range filters {
+			if !f(v) {
+				continue VersionLoop
+			}
+		}
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Number < versions[j].Number })
+	return versions, nil
 }
-*/
 
 // Clone this version of the service, returning the new version.
 func (v *Version) Clone() (*Version, error) {
+	return v.CloneContext(context.Background())
+}
+
+// Like Clone, but takes a context.Context for the request.
+func (v *Version) CloneContext(ctx context.Context) (*Version, error) {
 	u := fmt.Sprintf("/version/%d/clone", v.Number)
 	url := v.service.TaskURL(u)
-	resp, err := v.service.ghastly.Put(url, nil)
+	resp, err := v.service.ghastly.PutContext(ctx, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	vData, err := ParseJson(resp.Body)
+	// the service's list of versions has changed
+	v.service.ghastly.invalidateService(v.service.Id, false)
+	vData, err := ParseJsonTyped[versionData](resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	return v.service.populateVersion(vData)
+	return v.service.populateVersion(&vData)
+}
+
+// Lock this version, so it can't be changed any more.
+func (v *Version) Lock() error {
+	return v.LockContext(context.Background())
+}
+
+// Like Lock, but takes a context.Context for the request.
+func (v *Version) LockContext(ctx context.Context) error {
+	url := v.baseURL("lock")
+	resp, err := v.service.ghastly.PutContext(ctx, url, nil)
+	if err != nil {
+		return err
+	}
+	return v.refreshFromResponse(resp.Body)
 }
 
-/*
-// Activate this version of the service.
+// Update this version's comment.
+func (v *Version) Update(comment string) error {
+	return v.UpdateContext(context.Background(), comment)
+}
+
+// Like Update, but takes a context.Context for the request.
+func (v *Version) UpdateContext(ctx context.Context, comment string) error {
+	u := fmt.Sprintf("/version/%d", v.Number)
+	url := v.service.TaskURL(u)
+	params := map[string]string{"comment": comment}
+	resp, err := v.service.ghastly.PutParamsContext(ctx, url, params)
+	if err != nil {
+		return err
+	}
+	return v.refreshFromResponse(resp.Body)
+}
+
+// Activate this version of the service, making it the configuration that is
+// live. The service's ActiveVersion is updated to match.
 func (v *Version) Activate() error {
+	return v.ActivateContext(context.Background())
+}
+
+// Like Activate, but takes a context.Context for the request.
+func (v *Version) ActivateContext(ctx context.Context) error {
+	if err := v.setActive(ctx, "activate"); err != nil {
+		return err
+	}
+	v.service.ghastly.invalidateService(v.service.Id, true)
+	v.service.setActiveVersion(v.Number)
+	return nil
+}
+
+// Deactivate this version of the service, if it's the active version.
+func (v *Version) Deactivate() error {
+	return v.DeactivateContext(context.Background())
+}
 
+// Like Deactivate, but takes a context.Context for the request.
+func (v *Version) DeactivateContext(ctx context.Context) error {
+	if err := v.setActive(ctx, "deactivate"); err != nil {
+		return err
+	}
+	v.service.ghastly.invalidateService(v.service.Id, true)
+	if v.service.ActiveVersion == v.Number {
+		v.service.setActiveVersion(0)
+	}
+	return nil
+}
+
+// Activate this version, and then check the service every pollInterval until
+// Fastly reports this version as the active one. If pollInterval is zero, it
+// defaults to two seconds.
+func (v *Version) ActivateAndWait(pollInterval time.Duration) error {
+	return v.ActivateAndWaitContext(context.Background(), pollInterval)
+}
+
+// Like ActivateAndWait, but takes a context.Context to control the requests
+// and how long to wait.
+func (v *Version) ActivateAndWaitContext(ctx context.Context, pollInterval time.Duration) error {
+	if err := v.ActivateContext(ctx); err != nil {
+		return err
+	}
+	return v.waitActive(ctx, pollInterval)
+}
+
+// Poll the service until this version is the active one.
+func (v *Version) waitActive(ctx context.Context, pollInterval time.Duration) error {
+	if pollInterval == 0 {
+		pollInterval = 2 * time.Second
+	}
+	for {
+		s, err := v.service.ghastly.fetchService(ctx, v.service.Id)
+		if err != nil {
+			return err
+		}
+		if s.ActiveVersion == v.Number {
+			return nil
+		}
+		if err = sleepContext(ctx, pollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+func (v *Version) setActive(ctx context.Context, task string) error {
+	u := fmt.Sprintf("/version/%d/%s", v.Number, task)
+	url := v.service.TaskURL(u)
+	resp, err := v.service.ghastly.PutContext(ctx, url, nil)
+	if err != nil {
+		return err
+	}
+	return v.refreshFromResponse(resp.Body)
+}
+
+// Update this version from the copy of it in an API response.
+func (v *Version) refreshFromResponse(body io.ReadCloser) error {
+	vData, err := ParseJsonTyped[versionData](body)
+	if err != nil {
+		return err
+	}
+	nv, err := v.service.populateVersion(&vData)
+	if err != nil {
+		return err
+	}
+	v.refresh(nv)
+	return nil
+}
+
+// Update this version from a newer copy of it fetched from the API, and cache
+// this copy in place of the newer one.
+func (v *Version) refresh(nv *Version) {
+	v.Active = nv.Active
+	v.Locked = nv.Locked
+	v.Comment = nv.Comment
+	v.Testing = nv.Testing
+	v.Staging = nv.Staging
+	v.Deployed = nv.Deployed
+	v.Network = nv.Network
+	v.UpdatedAt = nv.UpdatedAt
+	v.deletedAt = nv.deletedAt
+	v.service.cacheVersion(v)
+	v.service.ghastly.invalidateVersion(v.service.Id, v.Number)
+}
+
+// The versions cached on the service, in order.
+func (s *Service) cachedVersions() []*Version {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := make([]*Version, 0, len(s.versions))
+	for _, v := range s.versions {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Number < versions[j].Number })
+	return versions
+}
+
+// Record which version of the service is active, here and on any cached
+// versions.
+func (s *Service) setActiveVersion(number int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ActiveVersion = number
+	for n, v := range s.versions {
+		v.Active = n == number
+	}
+}
+
+// Remember the most recently seen copy of a version.
+func (s *Service) cacheVersion(v *Version) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.versions == nil {
+		s.versions = make(map[int64]*Version)
+	}
+	s.versions[v.Number] = v
 }
-*/
 
 func (v *Version) baseURL(task string) string {
 	vURL := fmt.Sprintf("version/%d/%s", v.Number, task)
 	return v.service.TaskURL(vURL)
 }
+
+// The path to one object attached to a version, relative to the version. Names
+// can be any text, so they're escaped.
+func versionObjectPath(kind, name string) string {
+	return fmt.Sprintf("%s/%s", kind, url.PathEscape(name))
+}